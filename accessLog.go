@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 访问日志,拦截的http 请求每个请求一条,其它每个会话一条
+type AccessLog struct {
+	Time      time.Time     `json:"time"`
+	Client    string        `json:"client"`
+	User      string        `json:"user,omitempty"`
+	Protocol  string        `json:"protocol"`
+	Method    string        `json:"method,omitempty"`
+	Url       string        `json:"url,omitempty"`
+	Proto     string        `json:"proto,omitempty"`
+	Host      string        `json:"host,omitempty"`
+	Status    int           `json:"status,omitempty"`
+	Upstream  string        `json:"upstream,omitempty"`
+	UpBytes   int64         `json:"upBytes"`
+	DownBytes int64         `json:"downBytes"`
+	Duration  time.Duration `json:"duration"`
+	Referer   string        `json:"referer,omitempty"`
+	UserAgent string        `json:"userAgent,omitempty"`
+	Ja3       string        `json:"ja3,omitempty"` //客户端tls 指纹
+	Ja4       string        `json:"ja4,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// 访问日志输出
+type Logger interface {
+	Log(*AccessLog)
+}
+
+// 每行一个json
+type jsonLogger struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+func NewJsonLogger(w io.Writer) Logger {
+	return &jsonLogger{w: w}
+}
+func (obj *jsonLogger) Log(accessLog *AccessLog) {
+	content, err := json.Marshal(accessLog)
+	if err != nil {
+		return
+	}
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.w.Write(append(content, '\n'))
+}
+
+// apache combined 格式
+type combinedLogger struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+func NewCombinedLogger(w io.Writer) Logger {
+	return &combinedLogger{w: w}
+}
+func (obj *combinedLogger) Log(accessLog *AccessLog) {
+	host := accessLog.Client
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	requestLine := "-"
+	if accessLog.Method != "" {
+		proto := accessLog.Proto
+		if proto == "" {
+			proto = "HTTP/1.1"
+		}
+		requestLine = accessLog.Method + " " + accessLog.Url + " " + proto
+	}
+	line := fmt.Sprintf("%s - %s [%s] %q %s %s %q %q\n",
+		host,
+		combinedValue(accessLog.User),
+		accessLog.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		requestLine,
+		combinedValue(combinedInt(int64(accessLog.Status))),
+		combinedValue(combinedInt(accessLog.DownBytes)),
+		combinedValue(accessLog.Referer),
+		combinedValue(accessLog.UserAgent),
+	)
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	io.WriteString(obj.w, line)
+}
+func combinedValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+func combinedInt(value int64) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.FormatInt(value, 10)
+}
+
+// 会话日志
+func (obj *Session) accessLog() *AccessLog {
+	accessLog := &AccessLog{
+		Time:      obj.StartTime,
+		Client:    obj.ClientAddr.String(),
+		User:      obj.User,
+		Protocol:  obj.Protocol,
+		Method:    obj.Method,
+		Url:       obj.Url,
+		Host:      obj.Host,
+		Upstream:  obj.Upstream,
+		UpBytes:   obj.UpBytes(),
+		DownBytes: obj.DownBytes(),
+		Duration:  time.Since(obj.StartTime),
+	}
+	if obj.ClientHello != nil {
+		accessLog.Ja3 = obj.ClientHello.Ja3Hash
+		accessLog.Ja4 = obj.ClientHello.Ja4
+	}
+	return accessLog
+}
+
+// 请求日志,记录开始时的流量,结束时计算差值
+func (obj *Session) requestLog(req *http.Request) *AccessLog {
+	accessLog := obj.accessLog()
+	accessLog.Time = time.Now()
+	accessLog.Method = req.Method
+	accessLog.Url = req.URL.String()
+	accessLog.Proto = req.Proto
+	accessLog.Host = req.URL.Host
+	accessLog.Referer = req.Referer()
+	accessLog.UserAgent = req.UserAgent()
+	return accessLog
+}
+func (obj *AccessLog) finish(session *Session, rsp *http.Response, err error) *AccessLog {
+	if rsp != nil {
+		obj.Status = rsp.StatusCode
+	}
+	if err != nil {
+		obj.Error = err.Error()
+	}
+	obj.UpBytes = session.UpBytes() - obj.UpBytes
+	obj.DownBytes = session.DownBytes() - obj.DownBytes
+	obj.Duration = time.Since(obj.Time)
+	return obj
+}
@@ -0,0 +1,246 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errArchiveMiss = errors.New("archive: no recorded response")
+
+// 归档模式
+type ArchiveMode int
+
+const (
+	ArchiveRecord ArchiveMode = iota + 1 //录制,转发请求并保存请求和响应
+	ArchiveReplay                        //回放,不连接目标地址,从归档返回响应
+)
+
+// 录制回放配置,https 需要中间人解密
+type ArchiveOption struct {
+	Mode        ArchiveMode
+	Dir         string   //归档目录,每个请求保存一个json 文件
+	MatchBody   bool     //匹配请求body 的hash,录制和回放需要一致,开启后会读取完整的请求body
+	IgnoreQuery []string //回放时匹配url 忽略的查询参数
+	Strict      bool     //回放时没有匹配的请求返回502 并中断连接,否则返回404
+	MaxBodySize int64    //录制的最大响应body,超过时不保存该请求,默认10MB
+}
+
+// 归档的一个请求和响应
+type ArchiveEntry struct {
+	Time          time.Time   `json:"time"`
+	Method        string      `json:"method"`
+	Url           string      `json:"url"`
+	BodyHash      string      `json:"bodyHash"`
+	Status        int         `json:"status"`
+	Header        http.Header `json:"header"`
+	ContentLength int64       `json:"contentLength"`
+	Body          []byte      `json:"body"`
+}
+
+type archive struct {
+	option  ArchiveOption
+	seq     atomic.Int64
+	lock    sync.Mutex
+	entries map[string][]*ArchiveEntry //同一请求按录制顺序回放,最后一个重复使用
+}
+
+func newArchive(option ArchiveOption) (*archive, error) {
+	if option.Dir == "" {
+		return nil, errors.New("archive dir is empty")
+	}
+	if option.MaxBodySize <= 0 {
+		option.MaxBodySize = 10 << 20
+	}
+	obj := &archive{option: option}
+	switch option.Mode {
+	case ArchiveRecord:
+		return obj, os.MkdirAll(option.Dir, 0755)
+	case ArchiveReplay:
+		return obj, obj.load()
+	default:
+		return nil, errors.New("archive mode error")
+	}
+}
+func (obj *archive) replaying() bool {
+	return obj != nil && obj.option.Mode == ArchiveReplay
+}
+
+// 加载归档目录,文件名按录制顺序排序
+func (obj *archive) load() error {
+	fileNames, err := filepath.Glob(filepath.Join(obj.option.Dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(fileNames)
+	obj.entries = make(map[string][]*ArchiveEntry)
+	for _, fileName := range fileNames {
+		content, err := os.ReadFile(fileName)
+		if err != nil {
+			return err
+		}
+		var entry ArchiveEntry
+		if err = json.Unmarshal(content, &entry); err != nil {
+			return fmt.Errorf("archive %s: %w", fileName, err)
+		}
+		key := obj.key(entry.Method, entry.Url, entry.BodyHash)
+		obj.entries[key] = append(obj.entries[key], &entry)
+	}
+	return nil
+}
+
+// 请求的匹配key
+func (obj *archive) key(method string, href string, bodyHash string) string {
+	key := strings.ToUpper(method) + " " + href
+	if u, err := url.Parse(href); err == nil {
+		query := u.Query()
+		for _, name := range obj.option.IgnoreQuery {
+			query.Del(name)
+		}
+		u.RawQuery = query.Encode()
+		u.Fragment = ""
+		key = strings.ToUpper(method) + " " + u.String()
+	}
+	if obj.option.MatchBody {
+		key += " " + bodyHash
+	}
+	return key
+}
+func archiveBodyHash(body []byte) string {
+	hash := sha256.Sum256(body)
+	return hex.EncodeToString(hash[:])
+}
+
+// 读取完整的请求body,用于计算hash
+func readArchiveBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// 保存请求和响应,响应body 边转发边读取,读取完成后保存,流式响应和超过大小限制的响应不保存
+func (obj *archive) record(req *http.Request, reqBody []byte, rsp *http.Response) error {
+	entry := &ArchiveEntry{
+		Time:          time.Now(),
+		Method:        req.Method,
+		Url:           req.URL.String(),
+		Status:        rsp.StatusCode,
+		Header:        rsp.Header.Clone(),
+		ContentLength: rsp.ContentLength,
+	}
+	if obj.option.MatchBody {
+		entry.BodyHash = archiveBodyHash(reqBody)
+	}
+	if rsp.Body == nil || rsp.Body == http.NoBody {
+		return obj.save(entry)
+	}
+	if getStreamType(rsp) != "" || rsp.ContentLength > obj.option.MaxBodySize {
+		return nil
+	}
+	rsp.Body = &archiveBody{ReadCloser: rsp.Body, archive: obj, entry: entry}
+	return nil
+}
+
+// 转发响应body 时复制一份,读取完成后保存
+type archiveBody struct {
+	io.ReadCloser
+	archive *archive
+	entry   *ArchiveEntry
+	body    bytes.Buffer
+	done    bool //已保存或超过大小限制
+}
+
+func (obj *archiveBody) Read(p []byte) (int, error) {
+	n, err := obj.ReadCloser.Read(p)
+	if obj.done {
+		return n, err
+	}
+	if int64(obj.body.Len()+n) > obj.archive.option.MaxBodySize {
+		obj.done = true
+		obj.body = bytes.Buffer{}
+		return n, err
+	}
+	obj.body.Write(p[:n])
+	//长度已知时读完最后的数据就保存,客户端收到完整响应前归档已经写入
+	if err == io.EOF || (obj.entry.ContentLength >= 0 && int64(obj.body.Len()) == obj.entry.ContentLength) {
+		obj.done = true
+		obj.entry.Body = obj.body.Bytes()
+		obj.archive.save(obj.entry) //保存失败不影响转发
+	}
+	return n, err
+}
+
+// 写入归档文件
+func (obj *archive) save(entry *ArchiveEntry) error {
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	fileName := fmt.Sprintf("%020d_%06d.json", entry.Time.UnixNano(), obj.seq.Add(1))
+	return os.WriteFile(filepath.Join(obj.option.Dir, fileName), content, 0644)
+}
+
+// 从归档获取响应
+func (obj *archive) replay(req *http.Request, reqBody []byte) (*http.Response, error) {
+	key := obj.key(req.Method, req.URL.String(), archiveBodyHash(reqBody))
+	obj.lock.Lock()
+	var entry *ArchiveEntry
+	if entries := obj.entries[key]; len(entries) > 0 {
+		entry = entries[0]
+		if len(entries) > 1 {
+			obj.entries[key] = entries[1:]
+		}
+	}
+	obj.lock.Unlock()
+	if entry == nil {
+		statusCode := http.StatusNotFound
+		if obj.option.Strict {
+			statusCode = http.StatusBadGateway
+		}
+		rsp := newArchiveResponse(req, statusCode, http.Header{"X-Proxy-Archive": []string{"miss"}}, -1, nil)
+		if obj.option.Strict {
+			return rsp, fmt.Errorf("%w: %s", errArchiveMiss, key)
+		}
+		return rsp, nil
+	}
+	return newArchiveResponse(req, entry.Status, entry.Header.Clone(), entry.ContentLength, entry.Body), nil
+}
+func newArchiveResponse(req *http.Request, statusCode int, header http.Header, contentLength int64, body []byte) *http.Response {
+	if header == nil {
+		header = make(http.Header)
+	}
+	if len(body) > 0 || contentLength < 0 {
+		contentLength = int64(len(body))
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		ContentLength: contentLength,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		Request:       req,
+	}
+}
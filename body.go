@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// 解析Content-Encoding,返回按编码顺序排列的编码
+func contentEncodings(header http.Header) []string {
+	var encodings []string
+	for _, value := range header.Values("Content-Encoding") {
+		for _, encoding := range strings.Split(value, ",") {
+			if encoding = strings.ToLower(strings.TrimSpace(encoding)); encoding != "" && encoding != "identity" {
+				encodings = append(encodings, encoding)
+			}
+		}
+	}
+	return encodings
+}
+
+// 按编码倒序解码
+func decodeBody(content []byte, encodings []string) ([]byte, error) {
+	var err error
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var reader io.Reader
+		switch encodings[i] {
+		case "gzip", "x-gzip":
+			if reader, err = gzip.NewReader(bytes.NewReader(content)); err != nil {
+				return nil, err
+			}
+		case "deflate": //标准是zlib 格式,部分服务端返回原始deflate
+			if reader, err = zlib.NewReader(bytes.NewReader(content)); err != nil {
+				reader = flate.NewReader(bytes.NewReader(content))
+			}
+		case "br":
+			reader = brotli.NewReader(bytes.NewReader(content))
+		case "zstd":
+			decoder, err := zstd.NewReader(bytes.NewReader(content))
+			if err != nil {
+				return nil, err
+			}
+			defer decoder.Close()
+			reader = decoder
+		default:
+			return nil, fmt.Errorf("unsupported content encoding: %s", encodings[i])
+		}
+		if content, err = io.ReadAll(reader); err != nil {
+			return content, err
+		}
+	}
+	return content, nil
+}
+
+// 按编码顺序编码
+func encodeBody(content []byte, encodings []string) ([]byte, error) {
+	for _, encoding := range encodings {
+		var buf bytes.Buffer
+		var writer io.WriteCloser
+		var err error
+		switch encoding {
+		case "gzip", "x-gzip":
+			writer = gzip.NewWriter(&buf)
+		case "deflate":
+			writer = zlib.NewWriter(&buf)
+		case "br":
+			writer = brotli.NewWriter(&buf)
+		case "zstd":
+			if writer, err = zstd.NewWriter(&buf); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+		}
+		if _, err = writer.Write(content); err != nil {
+			return nil, err
+		}
+		if err = writer.Close(); err != nil {
+			return nil, err
+		}
+		content = buf.Bytes()
+	}
+	return content, nil
+}
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+	content, err := io.ReadAll(*body)
+	(*body).Close()
+	*body = io.NopCloser(bytes.NewReader(content))
+	return content, err
+}
+
+// 编码body,编码失败时删除Content-Encoding 发送原文,返回body 长度
+func setBody(header http.Header, body *io.ReadCloser, content []byte) int64 {
+	encodings := contentEncodings(header)
+	encoded, err := encodeBody(content, encodings)
+	if err != nil {
+		header.Del("Content-Encoding")
+		encoded = content
+	}
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(encoded)))
+	*body = io.NopCloser(bytes.NewReader(encoded))
+	return int64(len(encoded))
+}
+
+// 读取解码后的请求body,读取后请求可以继续转发
+func ReadRequestBody(req *http.Request) ([]byte, error) {
+	content, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+	return decodeBody(content, contentEncodings(req.Header))
+}
+
+// 读取解码后的响应body,读取后响应可以继续返回给客户端
+func ReadResponseBody(rsp *http.Response) ([]byte, error) {
+	content, err := readBody(&rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return decodeBody(content, contentEncodings(rsp.Header))
+}
+
+// 替换请求body,按Content-Encoding 重新编码并修正Content-Length
+func SetRequestBody(req *http.Request, content []byte) {
+	req.TransferEncoding = nil
+	req.ContentLength = setBody(req.Header, &req.Body, content)
+}
+
+// 替换响应body,按Content-Encoding 重新编码并修正Content-Length
+func SetResponseBody(rsp *http.Response, content []byte) {
+	rsp.TransferEncoding = nil
+	rsp.ContentLength = setBody(rsp.Header, &rsp.Body, content)
+}
+
+// 构造响应,用于中间件直接返回
+func NewResponse(req *http.Request, statusCode int, header http.Header, content []byte) *http.Response {
+	if header == nil {
+		header = make(http.Header)
+	}
+	rsp := &http.Response{
+		Status:     fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode: statusCode,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Request:    req,
+	}
+	SetResponseBody(rsp, content)
+	return rsp
+}
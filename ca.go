@@ -0,0 +1,332 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gospider007/gtls"
+)
+
+// 监听地址上下载根证书的路径
+const (
+	CaPemPath = "/ca.crt" //PEM 格式
+	CaDerPath = "/ca.der" //DER 格式
+)
+
+// 中间人根证书,签发中间人使用的证书
+type Ca struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// 加载PEM 格式的根证书和私钥,私钥支持EC,RSA,PKCS8
+func LoadCa(crtData []byte, keyData []byte) (*Ca, error) {
+	block, _ := pem.Decode(crtData)
+	if block == nil {
+		return nil, errors.New("ca cert pem error")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, errors.New("cert is not a ca")
+	}
+	if block, _ = pem.Decode(keyData); block == nil {
+		return nil, errors.New("ca key pem error")
+	}
+	var key any
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("ca key type error")
+	}
+	return &Ca{cert: cert, key: signer}, nil
+}
+
+// 生成新的根证书
+func NewCa(commonName string) (*Ca, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: commonName, Organization: []string{commonName}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Ca{cert: cert, key: key}, nil
+}
+
+// 从目录加载ca.crt,ca.key,不存在时生成并保存,多个进程同时生成时使用先创建私钥的
+func LoadOrCreateCa(dir string) (*Ca, error) {
+	crtPath := filepath.Join(dir, "ca.crt")
+	keyPath := filepath.Join(dir, "ca.key")
+	ca, err := loadCaFile(crtPath, keyPath)
+	if !os.IsNotExist(err) {
+		return ca, err
+	}
+	if ca, err = NewCa("gospider proxy CA"); err != nil {
+		return nil, err
+	}
+	keyData, err := ca.KeyPem()
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	keyFile, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) { //其它进程正在生成,等待根证书写入
+		for range 50 {
+			time.Sleep(time.Millisecond * 100)
+			if ca, err = loadCaFile(crtPath, keyPath); !os.IsNotExist(err) {
+				return ca, err
+			}
+		}
+		return nil, fmt.Errorf("ca %s: ca.key exists without ca.crt", dir)
+	} else if err != nil {
+		return nil, err
+	}
+	_, err = keyFile.Write(keyData)
+	if closeErr := keyFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	//根证书写入临时文件后改名,读取到根证书时私钥已经写完
+	tempFile, err := os.CreateTemp(dir, "ca.crt.*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err = tempFile.Write(ca.Pem()); err != nil {
+		tempFile.Close()
+		return nil, err
+	}
+	if err = tempFile.Close(); err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(tempFile.Name(), 0644); err != nil {
+		return nil, err
+	}
+	return ca, os.Rename(tempFile.Name(), crtPath)
+}
+func loadCaFile(crtPath string, keyPath string) (*Ca, error) {
+	crtData, err := os.ReadFile(crtPath)
+	if err != nil {
+		return nil, err
+	}
+	keyData, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return LoadCa(crtData, keyData)
+}
+
+// 默认的根证书目录,用户配置目录下的gospider/proxy
+func DefaultCaDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gospider", "proxy"), nil
+}
+
+// 默认的根证书,没有用户配置目录时使用临时根证书
+func defaultCa() (*Ca, error) {
+	dir, err := DefaultCaDir()
+	if err != nil {
+		return NewCa("gospider proxy CA")
+	}
+	return LoadOrCreateCa(dir)
+}
+
+// gtls 内置的根证书
+var builtinCaDer = sync.OnceValue(func() []byte {
+	block, _ := pem.Decode(gtls.CrtFile)
+	if block == nil {
+		return nil
+	}
+	return block.Bytes
+})
+
+// 是否是gtls 内置的根证书,私钥是公开的,不能让客户端安装信任
+func (obj *Ca) builtin() bool {
+	return bytes.Equal(obj.cert.Raw, builtinCaDer())
+}
+
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// 根证书
+func (obj *Ca) Certificate() *x509.Certificate {
+	return obj.cert
+}
+
+// PEM 格式的根证书,用于客户端安装信任
+func (obj *Ca) Pem() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: obj.cert.Raw})
+}
+
+// DER 格式的根证书
+func (obj *Ca) Der() []byte {
+	return obj.cert.Raw
+}
+
+// PEM 格式的私钥
+func (obj *Ca) KeyPem() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(obj.key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// 证书私钥类型
+type CertKeyType int
+
+const (
+	CertKeyEcdsa   CertKeyType = iota //ECDSA P-256
+	CertKeyRsa                        //RSA-2048
+	CertKeyEd25519                    //Ed25519
+)
+
+func (obj CertKeyType) generateKey() (crypto.Signer, error) {
+	switch obj {
+	case CertKeyRsa:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case CertKeyEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+}
+
+// 签发域名证书,域名为空时签发127.0.0.1 的证书,*.example.com 签发通配符证书
+func (obj *Ca) CreateCert(serverName string, keyType CertKeyType) (tls.Certificate, error) {
+	key, err := keyType.generateKey()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: serverName, Organization: obj.cert.Subject.Organization},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	if serverName == "" {
+		template.Subject.CommonName = "127.0.0.1"
+		template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	} else if ip := net.ParseIP(serverName); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{serverName}
+	}
+	if keyType == CertKeyRsa {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, obj.cert, key.Public(), obj.key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der, obj.cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// 是否是下载根证书的请求,直接发给监听地址的请求
+func isCaRequest(req *http.Request) bool {
+	if req.Method != http.MethodGet || !strings.HasPrefix(req.RequestURI, "/") {
+		return false
+	}
+	return req.URL.Path == CaPemPath || req.URL.Path == CaDerPath
+}
+
+// 返回根证书,内置的根证书不提供下载
+func (obj *Client) serveCa(client *ProxyConn, req *http.Request) error {
+	ca, err := obj.ca()
+	if err != nil {
+		return err
+	}
+	if ca.builtin() {
+		content := "the built-in CA key is public, set CaDir or CaCrt before installing a CA"
+		_, err := fmt.Fprintf(client, "%s 403 Forbidden\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", req.Proto, len(content), content)
+		return err
+	}
+	content, contentType := ca.Pem(), "application/x-pem-file"
+	if req.URL.Path == CaDerPath {
+		content, contentType = ca.Der(), "application/x-x509-ca-cert"
+	}
+	_, err = fmt.Fprintf(client, "%s 200 OK\r\nContent-Type: %s\r\nContent-Disposition: attachment; filename=\"%s\"\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		req.Proto, contentType, strings.TrimPrefix(req.URL.Path, "/"), len(content), content)
+	return err
+}
+
+// 中间人根证书,没有设置时第一次调用加载默认的根证书,加载失败返回nil
+func (obj *Client) Ca() *Ca {
+	ca, _ := obj.ca()
+	return ca
+}
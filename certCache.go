@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"container/list"
+	"crypto/tls"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// 中间人证书缓存配置
+type CertCacheOption struct {
+	MaxSize  int         //缓存的证书数量,默认1000,小于0 时不缓存
+	Wildcard bool        //子域名签发通配符证书,a.example.com 使用*.example.com,上级域名是公共后缀时不生效,如a.co.uk
+	KeyType  CertKeyType //证书私钥类型,默认ECDSA P-256
+}
+
+// 证书缓存统计
+type CertCacheStats struct {
+	Hits      int64 //命中次数
+	Misses    int64 //签发次数
+	Evictions int64 //淘汰次数
+	Size      int   //当前缓存数量
+}
+
+// 命中率
+func (obj CertCacheStats) HitRate() float64 {
+	if total := obj.Hits + obj.Misses; total > 0 {
+		return float64(obj.Hits) / float64(total)
+	}
+	return 0
+}
+
+type certEntry struct {
+	name string
+	cert *tls.Certificate
+	err  error
+	done chan struct{} //签发完成
+}
+
+// 按域名缓存签发的证书,lru 淘汰,同一个域名同时只签发一次
+type certCache struct {
+	ca      func() (*Ca, error)
+	option  CertCacheOption
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	stats   CertCacheStats
+}
+
+func newCertCache(ca func() (*Ca, error), option CertCacheOption) *certCache {
+	if option.MaxSize == 0 {
+		option.MaxSize = 1000
+	}
+	return &certCache{
+		ca:      ca,
+		option:  option,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// 证书使用的域名
+func (obj *certCache) certName(serverName string) string {
+	if !obj.option.Wildcard || net.ParseIP(serverName) != nil {
+		return serverName
+	}
+	_, parent, ok := strings.Cut(serverName, ".")
+	if !ok {
+		return serverName
+	}
+	if suffix, _ := publicsuffix.PublicSuffix(parent); suffix == parent { //*.co.uk 这种证书客户端不接受
+		return serverName
+	}
+	return "*." + parent
+}
+
+// 返回域名的证书,没有或过期时签发
+func (obj *certCache) get(serverName string) (*tls.Certificate, error) {
+	name := obj.certName(serverName)
+	if obj.option.MaxSize < 0 {
+		obj.lock.Lock()
+		obj.stats.Misses++
+		obj.lock.Unlock()
+		return obj.create(name)
+	}
+	obj.lock.Lock()
+	for { //等待期间其它协程可能放入了新的证书,加锁后重新检查
+		ele, ok := obj.entries[name]
+		if !ok {
+			break
+		}
+		entry := ele.Value.(*certEntry)
+		obj.lock.Unlock()
+		<-entry.done
+		obj.lock.Lock()
+		if entry.err == nil && time.Now().Add(time.Hour).Before(entry.cert.Leaf.NotAfter) {
+			obj.stats.Hits++
+			if entry.ele(obj) == ele {
+				obj.lru.MoveToFront(ele)
+			}
+			obj.lock.Unlock()
+			return entry.cert, nil
+		}
+		if entry.ele(obj) == ele {
+			obj.remove(ele)
+		}
+	}
+	obj.stats.Misses++
+	entry := &certEntry{name: name, done: make(chan struct{})}
+	obj.entries[name] = obj.lru.PushFront(entry)
+	for obj.lru.Len() > obj.option.MaxSize {
+		obj.remove(obj.lru.Back())
+		obj.stats.Evictions++
+	}
+	obj.lock.Unlock()
+	entry.cert, entry.err = obj.create(name)
+	close(entry.done)
+	if entry.err != nil {
+		obj.lock.Lock()
+		if ele := entry.ele(obj); ele != nil {
+			obj.remove(ele)
+		}
+		obj.lock.Unlock()
+	}
+	return entry.cert, entry.err
+}
+
+// 缓存中的位置,已经被淘汰时返回nil
+func (obj *certEntry) ele(cache *certCache) *list.Element {
+	if ele, ok := cache.entries[obj.name]; ok && ele.Value == obj {
+		return ele
+	}
+	return nil
+}
+
+func (obj *certCache) remove(ele *list.Element) {
+	delete(obj.entries, ele.Value.(*certEntry).name)
+	obj.lru.Remove(ele)
+}
+
+func (obj *certCache) create(name string) (*tls.Certificate, error) {
+	ca, err := obj.ca()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := ca.CreateCert(name, obj.option.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+func (obj *certCache) Stats() CertCacheStats {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	stats := obj.stats
+	stats.Size = obj.lru.Len()
+	return stats
+}
+
+// 代理监听的tls 配置,按sni 签发证书
+func (obj *certCache) serverConfig() *tls.Config {
+	return &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(chi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return obj.get(chi.ServerName)
+		},
+	}
+}
+
+// 中间人证书缓存统计
+func (obj *Client) CertCacheStats() CertCacheStats {
+	return obj.certs.Stats()
+}
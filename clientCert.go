@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+// 代理监听tls 时的客户端证书认证
+type ClientCertOption struct {
+	Roots    *x509.CertPool //验证客户端证书的根证书
+	Required bool           //必须提供客户端证书,为false 时没有证书的客户端可以使用账号密码或白名单认证
+	//证书映射为用户名,用于权限,路由,限流,流量配额,返回error 则拒绝连接
+	//默认使用CommonName,为空时依次使用第一个dns,email,uri SAN
+	GetUser func(cert *x509.Certificate) (string, error)
+}
+
+// 证书对应的用户名
+func certUser(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0]
+	}
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	}
+	return ""
+}
+
+// 设置代理监听验证客户端证书
+func (obj *ClientCertOption) serverConfig(tlsConfig *tls.Config) {
+	tlsConfig.ClientCAs = obj.Roots
+	if obj.Required {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+}
+
+// 客户端证书认证的用户名,没有证书时返回空
+func (obj *Client) clientCertUser(state tls.ConnectionState) (string, error) {
+	if obj.clientCert == nil || len(state.PeerCertificates) == 0 {
+		return "", nil
+	}
+	cert := state.PeerCertificates[0]
+	if obj.clientCert.GetUser != nil {
+		return obj.clientCert.GetUser(cert)
+	}
+	if usr := certUser(cert); usr != "" {
+		return usr, nil
+	}
+	return "", errors.New("client certificate has no user")
+}
+
+type sessionKey struct{}
+
+// 返回处理连接的上下文中的会话,GetProxy 等回调可以按认证的用户路由
+func GetSession(ctx context.Context) *Session {
+	session, _ := ctx.Value(sessionKey{}).(*Session)
+	return session
+}
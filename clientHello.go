@@ -0,0 +1,257 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gospider007/ja3"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// 客户端的tls 握手消息和指纹
+type ClientHello struct {
+	Raw        []byte    //握手消息,包含tls 记录头
+	ServerName string    //sni
+	Protocols  []string  //alpn
+	Ja3        string    //ja3 原始字符串
+	Ja3Hash    string    //ja3 的md5
+	Ja4        string    //ja4 指纹
+	Spec       *ja3.Spec //解析的握手消息
+}
+
+// 解析tls 握手消息,计算ja3,ja4
+func ParseClientHello(raw []byte) (*ClientHello, error) {
+	spec, err := ja3.ParseSpec(raw)
+	if err != nil {
+		return nil, err
+	}
+	if spec.ContentType != 22 || spec.HandShakeType != 1 {
+		return nil, errors.New("not client hello")
+	}
+	hello := &ClientHello{Raw: raw, Spec: spec}
+	var versions, curves, algorithms []uint16
+	var points []uint8
+	for _, ext := range spec.Extensions {
+		data := ext.Data
+		switch ext.Type {
+		case 0:
+			hello.ServerName = readServerName(data)
+		case 10:
+			var list cryptobyte.String
+			if data.ReadUint16LengthPrefixed(&list) {
+				curves = readUint16s(list)
+			}
+		case 11:
+			var list cryptobyte.String
+			if data.ReadUint8LengthPrefixed(&list) {
+				points = list
+			}
+		case 13:
+			var list cryptobyte.String
+			if data.ReadUint16LengthPrefixed(&list) {
+				algorithms = readUint16s(list)
+			}
+		case 16:
+			var list cryptobyte.String
+			if data.ReadUint16LengthPrefixed(&list) {
+				for !list.Empty() {
+					var protocol cryptobyte.String
+					if !list.ReadUint8LengthPrefixed(&protocol) {
+						break
+					}
+					hello.Protocols = append(hello.Protocols, string(protocol))
+				}
+			}
+		case 43:
+			var list cryptobyte.String
+			if data.ReadUint8LengthPrefixed(&list) {
+				versions = readUint16s(list)
+			}
+		}
+	}
+	ciphers := withoutGrease(spec.CipherSuites)
+	extensions := make([]uint16, 0, len(spec.Extensions))
+	for _, ext := range spec.Extensions {
+		if !isGrease(ext.Type) {
+			extensions = append(extensions, ext.Type)
+		}
+	}
+	curves = withoutGrease(curves)
+	//ja3: 版本,加密套件,扩展,曲线,点格式
+	pointValues := make([]uint16, len(points))
+	for i, point := range points {
+		pointValues[i] = uint16(point)
+	}
+	hello.Ja3 = strings.Join([]string{
+		strconv.Itoa(int(spec.HandshakeVersion)),
+		joinUint16s(ciphers, "%d", "-"),
+		joinUint16s(extensions, "%d", "-"),
+		joinUint16s(curves, "%d", "-"),
+		joinUint16s(pointValues, "%d", "-"),
+	}, ",")
+	hello.Ja3Hash = fmt.Sprintf("%x", md5.Sum([]byte(hello.Ja3)))
+	//ja4: 协议版本sni 数量alpn_排序的加密套件_排序的扩展和签名算法
+	version := spec.HandshakeVersion
+	if versions = withoutGrease(versions); len(versions) > 0 {
+		version = slices.Max(versions)
+	}
+	sni := "i"
+	if slices.Contains(extensions, 0) {
+		sni = "d"
+	}
+	ja4A := fmt.Sprintf("t%s%s%02d%02d%s", ja4Version(version), sni, min(len(ciphers), 99), min(len(extensions), 99), ja4Alpn(hello.Protocols))
+	sortedCiphers := slices.Clone(ciphers)
+	slices.Sort(sortedCiphers)
+	sortedExtensions := make([]uint16, 0, len(extensions))
+	for _, ext := range extensions {
+		if ext != 0 && ext != 16 {
+			sortedExtensions = append(sortedExtensions, ext)
+		}
+	}
+	slices.Sort(sortedExtensions)
+	ja4C := joinUint16s(sortedExtensions, "%04x", ",")
+	if algorithms = withoutGrease(algorithms); len(algorithms) > 0 {
+		ja4C += "_" + joinUint16s(algorithms, "%04x", ",")
+	}
+	hello.Ja4 = ja4A + "_" + ja4Hash(len(sortedCiphers), joinUint16s(sortedCiphers, "%04x", ",")) + "_" + ja4Hash(len(sortedExtensions), ja4C)
+	return hello, nil
+}
+
+// grease 值,0x0a0a,0x1a1a ...
+func isGrease(value uint16) bool {
+	return value&0x0f0f == 0x0a0a && value>>8 == value&0xff
+}
+func withoutGrease(values []uint16) []uint16 {
+	return slices.DeleteFunc(slices.Clone(values), isGrease)
+}
+func readUint16s(data cryptobyte.String) []uint16 {
+	var values []uint16
+	var value uint16
+	for data.ReadUint16(&value) {
+		values = append(values, value)
+	}
+	return values
+}
+func joinUint16s(values []uint16, format string, sep string) string {
+	items := make([]string, len(values))
+	for i, value := range values {
+		items[i] = fmt.Sprintf(format, value)
+	}
+	return strings.Join(items, sep)
+}
+func ja4Version(version uint16) string {
+	switch version {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	default:
+		return "00"
+	}
+}
+
+// 第一个alpn 的首尾字符,不是字母数字时使用十六进制的首尾字符
+func ja4Alpn(protocols []string) string {
+	if len(protocols) == 0 || protocols[0] == "" {
+		return "00"
+	}
+	protocol := protocols[0]
+	first, last := protocol[0], protocol[len(protocol)-1]
+	if !isAlphanumeric(first) || !isAlphanumeric(last) {
+		value := hex.EncodeToString([]byte(protocol))
+		return value[:1] + value[len(value)-1:]
+	}
+	return string([]byte{first, last})
+}
+func isAlphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+func ja4Hash(count int, value string) string {
+	if count == 0 {
+		return "000000000000"
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(value)))[:12]
+}
+
+// sni 扩展中的域名
+func readServerName(data cryptobyte.String) string {
+	var names cryptobyte.String
+	if !data.ReadUint16LengthPrefixed(&names) {
+		return ""
+	}
+	for !names.Empty() {
+		var nameType uint8
+		var name cryptobyte.String
+		if !names.ReadUint8(&nameType) || !names.ReadUint16LengthPrefixed(&name) {
+			return ""
+		}
+		if nameType == 0 {
+			return string(name)
+		}
+	}
+	return ""
+}
+
+// 客户端连接的读缓冲区大小,能预读一个最大的tls 记录
+const clientReaderSize = 5 + 16384
+
+// 预读客户端的tls 握手消息,不消耗数据
+// 握手消息分成多个tls 记录时合并成一个记录,超过读缓冲区大小时返回错误
+func peekClientHello(reader *bufio.Reader) (*ClientHello, error) {
+	var msg []byte
+	var head []byte
+	for offset := 0; ; {
+		data, err := reader.Peek(offset + 5)
+		if err != nil {
+			return nil, err
+		}
+		if data[offset] != 22 {
+			return nil, errors.New("not tls handshake")
+		}
+		if head == nil {
+			head = bytes.Clone(data[:5])
+		}
+		end := offset + 5 + int(binary.BigEndian.Uint16(data[offset+3:offset+5]))
+		if data, err = reader.Peek(end); err != nil {
+			return nil, err
+		}
+		msg = append(msg, data[offset+5:end]...)
+		offset = end
+		if len(msg) >= 4 && len(msg) >= 4+(int(msg[1])<<16|int(msg[2])<<8|int(msg[3])) {
+			break
+		}
+	}
+	if len(msg) > 0xffff {
+		return nil, errors.New("client hello too large")
+	}
+	binary.BigEndian.PutUint16(head[3:5], uint16(len(msg)))
+	return ParseClientHello(append(head, msg...))
+}
+
+// 中间人解密前记录客户端的握手消息
+func (obj *Client) readClientHello(client *ProxyConn) error {
+	hello, err := peekClientHello(client.reader)
+	if err != nil { //握手消息超过缓冲区等无法解析时不记录
+		return nil
+	}
+	client.option.session.ClientHello = hello
+	if obj.clientHelloCallBack != nil {
+		return obj.clientHelloCallBack(client.option.session, hello)
+	}
+	return nil
+}
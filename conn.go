@@ -21,13 +21,19 @@ type ProxyOption struct {
 	port         string
 	isWs         bool
 	wsExtensions string
+	session      *Session
+	certUser     string //客户端证书认证的用户名
+	forward      bool   //http 代理请求,不是connect 或socks5 隧道
 }
 type ProxyConn struct {
-	client bool
-	conn   net.Conn
-	req    *http.Request
-	reader *bufio.Reader
-	option *ProxyOption
+	client     bool
+	conn       net.Conn
+	req        *http.Request
+	upgradeReq *http.Request //协议升级的请求,只设置在客户端连接上
+	reader     *bufio.Reader
+	option     *ProxyOption
+	target     string //连接的目标地址 host:port,只设置在服务端连接上
+	poolKey    string //连接池的key,为空时不复用
 }
 
 func newProxyCon(conn net.Conn, reader *bufio.Reader, option ProxyOption, client bool) *ProxyConn {
@@ -53,6 +59,21 @@ func (obj *ProxyConn) Write(b []byte) (int, error) {
 	}
 	return n, err
 }
+
+// 空闲连接是否可用,服务端已经关闭或发送了数据的连接不能复用
+func (obj *ProxyConn) alive() bool {
+	if obj.reader.Buffered() > 0 {
+		return false
+	}
+	if err := obj.conn.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		return false
+	}
+	_, err := obj.reader.Peek(1)
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		return false
+	}
+	return obj.conn.SetReadDeadline(time.Time{}) == nil
+}
 func (obj *ProxyConn) Close() error {
 	return obj.conn.Close()
 }
@@ -99,15 +120,25 @@ func (obj *ProxyConn) readRequest(ctx context.Context, requestCallBack func(*htt
 	case <-done:
 	}
 	if err != nil {
+		if client != nil { //客户端的第一个请求
+			err = withStage(StageSniff, err)
+		}
 		return clientReq, err
 	}
-	if client != nil {
+	if client != nil && !isCaRequest(clientReq) { //下载根证书不需要验证
+		clientReq = clientReq.WithContext(ctx) //验证回调可以获取会话
 		if client.verifyAuthWithHttp != nil {
 			if err = client.verifyAuthWithHttp(clientReq); err != nil {
-				return clientReq, err
+				return clientReq, withStage(StageAuth, err)
 			}
-		} else if err = client.verifyPwd(obj, clientReq); err != nil {
-			return clientReq, err
+			if obj.option.certUser == "" { //回调验证通过的用户名
+				obj.option.session.User = getProxyAuthUser(clientReq)
+			}
+		} else if obj.option.certUser == "" {
+			if err = client.verifyPwd(obj, clientReq); err != nil {
+				return clientReq, withStage(StageAuth, err)
+			}
+			obj.option.session.User = client.basicUser(clientReq) //白名单等没有验证账号密码时为空
 		}
 	}
 	if requestCallBack != nil {
@@ -158,19 +189,19 @@ func (obj *ProxyConn) readRequest(ctx context.Context, requestCallBack func(*htt
 	} else if clientReq.URL.Scheme == "" {
 		clientReq.URL.Scheme = obj.option.schema
 	}
-	if obj.option.port == "" {
-		if clientReq.URL.Port() == "" {
-			if obj.option.schema == "https" {
-				obj.option.port = "443"
-			} else {
-				obj.option.port = "80"
-			}
-			clientReq.URL.Host = clientReq.URL.Hostname() + ":" + obj.option.port
+	port := clientReq.URL.Port()
+	if port == "" {
+		if obj.option.port != "" && !obj.option.forward {
+			port = obj.option.port
+		} else if clientReq.URL.Scheme == "https" { //http 代理请求按各自的scheme 取默认端口
+			port = "443"
 		} else {
-			obj.option.port = clientReq.URL.Port()
+			port = "80"
 		}
-	} else if clientReq.URL.Port() == "" {
-		clientReq.URL.Host = clientReq.URL.Hostname() + ":" + obj.option.port
+		clientReq.URL.Host = net.JoinHostPort(clientReq.URL.Hostname(), port)
+	}
+	if obj.option.port == "" {
+		obj.option.port = port
 	}
 	return clientReq, err
 }
@@ -5,45 +5,20 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
-	"log"
+	"fmt"
 	"net"
+	"net/url"
 	"slices"
 
 	"net/http"
 
 	"github.com/gospider007/gtls"
 	"github.com/gospider007/http2"
+	"github.com/gospider007/ja3"
 	"github.com/gospider007/tools"
-	"github.com/gospider007/websocket"
 	utls "github.com/refraction-networking/utls"
 )
 
-func (obj *Client) wsCopy(wsWriter *websocket.Conn, wsReader *websocket.Conn) (err error) {
-	defer wsWriter.Close()
-	defer wsReader.Close()
-	var msgType websocket.MessageType
-	var msgData []byte
-	for {
-		if msgType, msgData, err = wsReader.ReadMessage(); err != nil {
-			return
-		}
-		if obj.wsCallBack != nil {
-			if wsReader.IsClient() {
-				if err = obj.wsCallBack(msgType, msgData, WsRecv); err != nil {
-					return err
-				}
-			} else {
-				if err = obj.wsCallBack(msgType, msgData, WsSend); err != nil {
-					return err
-				}
-			}
-		}
-		if err = wsWriter.WriteMessage(msgType, msgData); err != nil {
-			return
-		}
-	}
-}
-
 func (obj *Client) TlsConfig() *tls.Config {
 	return obj.tlsConfig.Clone()
 }
@@ -59,9 +34,14 @@ func (obj *Client) http22Copy(preCtx context.Context, client *ProxyConn, server
 	defer server.Close()
 
 	http2Client := http2.Http2NewReaderFramer(client)
-	http2Server := http2.Http2NewReaderFramer(client)
+	http2Server := http2.Http2NewReaderFramer(server)
+	var tap *h2Tap
+	if obj.bodyTap != nil {
+		tap = newH2Tap(obj.bodyTap, client.option.session)
+	}
 	// client.option.gospiderSpec.H2Spec
 	go func() {
+		defer obj.recoverGoroutine(client.option.session)
 		defer client.Close()
 		defer server.Close()
 		for {
@@ -69,6 +49,7 @@ func (obj *Client) http22Copy(preCtx context.Context, client *ProxyConn, server
 			if err != nil {
 				return
 			}
+			tap.frame(data, BodyResponse)
 			_, err = client.Write(data)
 			if err != nil {
 				return
@@ -80,6 +61,7 @@ func (obj *Client) http22Copy(preCtx context.Context, client *ProxyConn, server
 		if err != nil {
 			return err
 		}
+		tap.frame(data, BodyRequest)
 		_, err = server.Write(data)
 		if err != nil {
 			return err
@@ -128,54 +110,195 @@ func (obj *Client) http22Copy(preCtx context.Context, client *ProxyConn, server
 //			}
 //		}
 //	}
-func (obj *Client) http11Copy(ctx context.Context, client *ProxyConn, server *ProxyConn) (err error) {
+//
+// 返回最后使用的服务端连接,请求的目标地址改变时会重新连接
+func (obj *Client) http11Copy(ctx context.Context, client *ProxyConn, server *ProxyConn) (_ *ProxyConn, err error) {
 	var req *http.Request
 	var rsp *http.Response
+	forward := client.option.forward //http 代理请求,每个请求单独获取服务端连接
+
+	var origin string //隧道的目标地址,只有map 规则可以改变请求的目标地址
+	if !forward {
+		if server != nil {
+			origin = server.target
+		} else if client.option.method == http.MethodConnect { //中间人解密的connect 隧道,还没有连接服务端
+			origin = net.JoinHostPort(mitmHost(client.option.host), client.option.port)
+		}
+	}
+	target := client.option.session.Host //http 代理请求当前的目标地址,改变时重新验证
 	for {
 		if client.req != nil {
 			req, client.req = client.req, nil
 		} else {
 			if req, err = client.readRequest(ctx, obj.requestCallBack, nil); err != nil {
-				return
+				return server, err
 			}
 		}
-		if err = req.Write(server); err != nil {
-			return
+		if forward && req.URL.Host != target {
+			if err = obj.verifyRequest(client, req); err != nil {
+				if obj.logger != nil {
+					client.option.session.logged.Store(true)
+					obj.logger.Log(client.option.session.requestLog(req).finish(client.option.session, nil, err))
+				}
+				return server, err
+			}
+			target = req.URL.Host
+		}
+		href := req.URL
+		if !obj.remapRequest(req) && origin != "" && origin != href.Host {
+			href = &url.URL{Scheme: href.Scheme, Host: origin}
+		}
+		if !obj.archive.replaying() && obj.mapLocalRule(req) == nil && (server == nil || forward || server.target != href.Host) {
+			if server != nil {
+				server.Close()
+			}
+			if server, err = obj.dialRequest(ctx, client, href); err != nil {
+				if forward || obj.isUntrusted(err) { //隧道已经和客户端完成握手,验证失败时无法返回无效的证书,返回502
+					client.Write([]byte(fmt.Sprintf("%s 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n", req.Proto)))
+				}
+				return nil, err
+			}
+		}
+		var accessLog *AccessLog
+		if obj.logger != nil {
+			accessLog = client.option.session.requestLog(req)
+		}
+		rsp, err = obj.http11RoundTrip(client, server, req)
+		if accessLog != nil {
+			client.option.session.logged.Store(true)
+			obj.logger.Log(accessLog.finish(client.option.session, rsp, err))
+		}
+		if err != nil {
+			return server, err
+		}
+		if rsp.StatusCode == 101 {
+			client.upgradeReq = req
+			return server, nil
+		}
+		if forward && server != nil {
+			if rsp.Close || req.Close {
+				server.Close()
+			} else {
+				obj.pool.put(server)
+			}
+			server = nil
 		}
-		if rsp, err = server.readResponse(req); err != nil {
+	}
+}
+
+// 转发一个请求,并把响应写回客户端
+func (obj *Client) http11RoundTrip(client *ProxyConn, server *ProxyConn, req *http.Request) (rsp *http.Response, err error) {
+	obj.forwardRequestHeader(client, req)
+	obj.rewriteRequestHeader(req)
+	index := 0 //执行过OnRequest 的中间件数量
+	for ; index < len(obj.middlewares) && rsp == nil; index++ {
+		if rsp, err = obj.middlewares[index].OnRequest(req); err != nil {
 			return
 		}
-		if obj.requestCallBack != nil {
-			if err = obj.requestCallBack(req, rsp); err != nil {
+	}
+	var exchange *harExchange
+	if rsp == nil { //中间件没有返回响应,转发请求
+		var reqBody []byte
+		if obj.archive != nil && obj.archive.option.MatchBody {
+			if reqBody, err = readArchiveBody(req); err != nil {
 				return
 			}
 		}
-		if err = rsp.Write(client); err != nil {
+		if obj.har != nil {
+			exchange = obj.har.newExchange(req)
+			defer func() {
+				obj.har.add(client.option.session, exchange.entry(server, req, rsp))
+			}()
+		}
+		if rule := obj.mapLocalRule(req); rule != nil {
+			exchange.sent()
+			rsp = rule.response(req)
+		} else if obj.archive.replaying() {
+			exchange.sent()
+			if rsp, err = obj.archive.replay(req, reqBody); err != nil {
+				rsp.Write(client)
+				return
+			}
+		} else {
+			obj.tapRequest(client, req)
+			if err = req.Write(server); err != nil {
+				return
+			}
+			exchange.sent()
+			if rsp, err = server.readResponse(req); err != nil {
+				return
+			}
+			if obj.archive != nil {
+				if err = obj.archive.record(req, reqBody, rsp); err != nil {
+					return
+				}
+			}
+		}
+	}
+	removeHopHeaders(rsp.Header) //响应也删除逐跳头
+	for i := index - 1; i >= 0; i-- {
+		if err = obj.middlewares[i].OnResponse(req, rsp); err != nil {
 			return
 		}
-		if rsp.StatusCode == 101 {
+	}
+	obj.rewriteResponseHeader(req, rsp)
+	obj.streamResponse(client, req, rsp)
+	exchange.received(rsp)
+	obj.tapResponse(client, req, rsp)
+	if obj.requestCallBack != nil {
+		if err = obj.requestCallBack(req, rsp); err != nil {
 			return
 		}
 	}
+	err = rsp.Write(client)
+	return
+}
+
+// 是否需要解析websocket 消息
+func (obj *Client) needWs() bool {
+	return obj.wsCallBack != nil || obj.wsHandler != nil
+}
+
+// 是否需要解析http 请求
+func (obj *Client) needIntercept() bool {
+	return obj.requestCallBack != nil || obj.needHttp1()
+}
+
+// 是否只能解析http1.1 请求,中间人解密时强制http1.1,只有RequestCallBack 时h2 直接转发帧
+func (obj *Client) needHttp1() bool {
+	return len(obj.middlewares) > 0 ||
+		obj.har != nil ||
+		obj.archive != nil ||
+		len(obj.mapLocal) > 0 ||
+		len(obj.mapRemote) > 0 ||
+		len(obj.headerRules) > 0 ||
+		obj.streamCallBack != nil
 }
 
 func (obj *Client) copyMain(ctx context.Context, client *ProxyConn, server *ProxyConn) (err error) {
 	if client.option.schema == "http" {
 		return obj.copyHttpMain(ctx, client, server)
 	} else if client.option.schema == "https" {
-		if obj.requestCallBack != nil ||
-			obj.wsCallBack != nil ||
-			client.option.gospiderSpec != nil ||
-			client.option.method != http.MethodConnect {
+		if client.option.method != http.MethodConnect {
 			return obj.copyHttpsMain(ctx, client, server)
 		}
-		return obj.copyHttpMain(ctx, client, server)
+		if (obj.needIntercept() ||
+			obj.needWs() ||
+			obj.bodyTap != nil ||
+			client.option.gospiderSpec != nil) && obj.mitmClient(client) {
+			return obj.copyHttpsMain(ctx, client, server)
+		}
+		return obj.tunnelCopy(ctx, client, server) //不解密直接转发
 	} else {
 		return errors.New("schema error")
 	}
 }
 func (obj *Client) copyHttpMain(ctx context.Context, client *ProxyConn, server *ProxyConn) (err error) {
-	defer server.Close()
+	defer func() { //请求可能切换了服务端连接
+		if server != nil {
+			server.Close()
+		}
+	}()
 	defer client.Close()
 	if client.option.http2 && !server.option.http2 { //http21 逻辑
 		return errors.New("没有21逻辑")
@@ -184,18 +307,19 @@ func (obj *Client) copyHttpMain(ctx context.Context, client *ProxyConn, server *
 	// 	return obj.http12Copy(ctx, client, server)
 	// }
 	if client.option.http2 && server.option.http2 { //http22 逻辑
-		if obj.requestCallBack != nil ||
+		if obj.needIntercept() || obj.bodyTap != nil ||
 			(client.option.gospiderSpec != nil && client.option.gospiderSpec.H2Spec != nil) { //需要拦截请求 或需要设置h2指纹，就走12
 			return obj.http22Copy(ctx, client, server)
 		}
 		go func() {
+			defer obj.recoverGoroutine(client.option.session)
 			defer client.Close()
 			defer server.Close()
 			tools.CopyWitchContext(ctx, client, server)
 		}()
 		return tools.CopyWitchContext(ctx, server, client)
 	}
-	if obj.wsCallBack == nil && obj.requestCallBack == nil { //没有回调直接返回
+	if !obj.needWs() && !obj.needIntercept() && obj.bodyTap == nil && !client.option.forward { //没有回调的隧道直接转发,http 代理请求需要处理请求头
 		if client.req != nil {
 			if err = client.req.Write(server); err != nil {
 				return err
@@ -203,6 +327,7 @@ func (obj *Client) copyHttpMain(ctx context.Context, client *ProxyConn, server *
 			client.req = nil
 		}
 		go func() {
+			defer obj.recoverGoroutine(client.option.session)
 			defer client.Close()
 			defer server.Close()
 			err = tools.CopyWitchContext(ctx, client, server)
@@ -210,24 +335,90 @@ func (obj *Client) copyHttpMain(ctx context.Context, client *ProxyConn, server *
 		err = tools.CopyWitchContext(ctx, server, client)
 		return
 	}
-	if err = obj.http11Copy(ctx, client, server); err != nil { //http11 开始回调
-		log.Print(err)
+	if server, err = obj.http11Copy(ctx, client, server); err != nil { //http11 开始回调
 		return err
 	}
-	if obj.wsCallBack == nil { //没有ws 回调直接返回
+	return obj.upgradeCopy(ctx, client, server)
+}
+
+// 隧道直接转发,不解析数据
+func (obj *Client) tunnelCopy(ctx context.Context, client *ProxyConn, server *ProxyConn) error {
+	defer server.Close()
+	defer client.Close()
+	go func() {
+		defer obj.recoverGoroutine(client.option.session)
+		defer client.Close()
+		defer server.Close()
+		tools.CopyWitchContext(ctx, client, server)
+	}()
+	return tools.CopyWitchContext(ctx, server, client)
+}
+
+// 协议升级后转发数据,有ws 回调时解析websocket 消息
+func (obj *Client) upgradeCopy(ctx context.Context, client *ProxyConn, server *ProxyConn) error {
+	if !obj.needWs() { //没有ws 回调直接返回
 		go func() {
+			defer obj.recoverGoroutine(client.option.session)
 			defer client.Close()
 			defer server.Close()
 			tools.CopyWitchContext(ctx, client, server)
 		}()
 		return tools.CopyWitchContext(ctx, server, client)
 	}
-	//ws 开始回调
-	wsServer := websocket.NewConn(client, false, server.option.wsExtensions)
-	wsClient := websocket.NewConn(server, true, server.option.wsExtensions)
-	log.Print(server.option.wsExtensions)
-	go obj.wsCopy(wsClient, wsServer)
-	return obj.wsCopy(wsServer, wsClient)
+	return obj.wsCopy(client, server) //ws 开始回调
+}
+
+// 不预先连接目标地址,按请求连接服务端,connect 请求中间人解密,回放模式不连接
+func (obj *Client) lazyMain(ctx context.Context, client *ProxyConn) (err error) {
+	if client.option.method == http.MethodConnect {
+		httpsBytes, err := client.reader.Peek(1)
+		if err != nil {
+			return err
+		}
+		if httpsBytes[0] == 22 { //tls 握手,中间人解密
+			if err = obj.readClientHello(client); err != nil {
+				return err
+			}
+			var mitmName string
+			tlsConfig := obj.TlsConfig()
+			tlsConfig.GetConfigForClient = func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
+				serverName := chi.ServerName
+				if serverName == "" {
+					serverName = gtls.GetServerName(client.option.host)
+				}
+				cert, err := obj.certs.get(serverName)
+				if err != nil {
+					return nil, err
+				}
+				tlsConfig2 := obj.TlsConfig()
+				tlsConfig2.Certificates = []tls.Certificate{*cert}
+				tlsConfig2.NextProtos = []string{"http/1.1"}
+				mitmName = serverName
+				return tlsConfig2, nil
+			}
+			tlsClient := tls.Server(client, tlsConfig)
+			err = tlsClient.HandshakeContext(ctx)
+			if mitmName != "" { //已发送证书,记录客户端握手结果
+				obj.mitm.handshake(mitmName, err)
+			}
+			if err != nil {
+				return withStage(StageTlsHandshake, err)
+			}
+			client = newProxyCon(tlsClient, bufio.NewReader(tlsClient), *client.option, true)
+		} else { //隧道中是明文http
+			client.option.schema = "http"
+		}
+	}
+	defer client.Close()
+	server, err := obj.http11Copy(ctx, client, nil)
+	if server == nil {
+		return err
+	}
+	defer server.Close()
+	if err != nil {
+		return err
+	}
+	return obj.upgradeCopy(ctx, client, server)
 }
 func (obj *Client) copyHttpsMain(ctx context.Context, client *ProxyConn, server *ProxyConn) (err error) {
 	httpsBytes, err := client.reader.Peek(1)
@@ -237,7 +428,7 @@ func (obj *Client) copyHttpsMain(ctx context.Context, client *ProxyConn, server
 	if httpsBytes[0] != 22 { //客户端直连
 		if client.option.method != http.MethodConnect { //服务端tls
 			var nextProtos []string
-			if client.option.isWs || server.option.isWs {
+			if client.option.isWs || server.option.isWs || obj.needIntercept() || obj.needWs() {
 				nextProtos = []string{"http/1.1"}
 			} else {
 				nextProtos = []string{"h2", "http/1.1"}
@@ -246,40 +437,62 @@ func (obj *Client) copyHttpsMain(ctx context.Context, client *ProxyConn, server
 			if err != nil {
 				return err
 			}
+			target := server.target
 			server = newProxyCon(tlsServer, bufio.NewReader(tlsServer), *server.option, false)
+			server.target = target
 			server.option.http2 = negotiatedProtocol == "h2"
 		}
 		return obj.copyHttpMain(ctx, client, server)
 	}
+	if err = obj.readClientHello(client); err != nil {
+		return err
+	}
 	var tlsClient *tls.Conn
 	var tlsServer net.Conn
 	var negotiatedProtocol string
+	var mitmName string
 	tlsConfig := obj.TlsConfig()
 	tlsConfig.GetConfigForClient = func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
 		serverName := chi.ServerName
 		if serverName == "" {
 			serverName = gtls.GetServerName(client.option.host)
 		}
-		tlsServer, negotiatedProtocol, err = obj.tlsServer(ctx, server, serverName, chi.SupportedProtos, client.option)
-		if err != nil {
+		nextProtos := chi.SupportedProtos
+		if obj.needHttp1() || obj.wsHandler != nil { //h2 无法解析请求,强制http1.1
+			nextProtos = []string{"http/1.1"}
+		}
+		tlsServer, negotiatedProtocol, err = obj.tlsServer(ctx, server, serverName, nextProtos, client.option)
+		untrusted := obj.isUntrusted(err)
+		if err != nil && !untrusted {
 			return nil, err
 		}
 		if negotiatedProtocol == "" {
 			negotiatedProtocol = "http/1.1"
 		}
-		var cert tls.Certificate
-		cert, err = gtls.CreateCertWithName(serverName)
+		var cert *tls.Certificate
+		if untrusted { //服务端证书验证失败,返回无效的证书
+			cert, err = untrustedCert(serverName)
+		} else {
+			cert, err = obj.certs.get(serverName)
+		}
 		if err != nil {
 			return nil, err
 		}
 		tlsConfig2 := obj.TlsConfig()
-		tlsConfig2.Certificates = []tls.Certificate{cert}
+		tlsConfig2.Certificates = []tls.Certificate{*cert}
 		tlsConfig2.NextProtos = []string{negotiatedProtocol}
+		if !untrusted {
+			mitmName = serverName
+		}
 		return tlsConfig2, nil
 	}
 	tlsClient = tls.Server(client, tlsConfig)
-	if err = tlsClient.HandshakeContext(ctx); err != nil {
-		return err
+	err = tlsClient.HandshakeContext(ctx)
+	if mitmName != "" { //已发送证书,记录客户端握手结果
+		obj.mitm.handshake(mitmName, err)
+	}
+	if err != nil {
+		return withStage(StageTlsHandshake, err)
 	}
 	server.option.http2 = negotiatedProtocol == "h2"
 	client.option.http2 = tlsClient.ConnectionState().NegotiatedProtocol == "h2"
@@ -287,24 +500,51 @@ func (obj *Client) copyHttpsMain(ctx context.Context, client *ProxyConn, server
 	//重新包装连接
 	clientProxy := newProxyCon(tlsClient, bufio.NewReader(tlsClient), *client.option, true)
 	serverProxy := newProxyCon(tlsServer, bufio.NewReader(tlsServer), *server.option, false)
+	serverProxy.target = server.target
 	return obj.copyHttpMain(ctx, clientProxy, serverProxy)
 }
 func (obj *Client) tlsServer(ctx context.Context, conn net.Conn, addr string, nextProtos []string, clientOption *ProxyOption) (net.Conn, string, error) {
-	if clientOption.gospiderSpec.TLSSpec != nil {
-		utlsConfig := obj.UtlsConfig()
-		utlsConfig.NextProtos = nextProtos
-		tlsConn, err := obj.specClient.Client(ctx, conn, clientOption.gospiderSpec.TLSSpec, utlsConfig, gtls.GetServerName(addr), !slices.Contains(nextProtos, "h2"))
-		if err != nil {
-			return tlsConn, "", err
+	if obj.mirrorSpec && clientOption.session.ClientHello != nil { //使用客户端的握手消息,保持客户端的指纹
+		hello := clientOption.session.ClientHello
+		//需要解析http1.1 时只能把客户端的h2 改为http/1.1,其它时候alpn 和客户端一致
+		forceHttp1 := slices.Contains(hello.Protocols, "h2") && !slices.Contains(nextProtos, "h2")
+		tlsConn, negotiatedProtocol, err := obj.utlsServer(ctx, conn, addr, forceHttp1, hello.Spec, clientOption)
+		if tlsConn != nil || err == nil {
+			clientOption.session.MirrorHttp1 = forceHttp1
+			return tlsConn, negotiatedProtocol, err
 		}
-		return tlsConn, tlsConn.ConnectionState().NegotiatedProtocol, nil
+		//utls 无法复现握手消息,使用设置的指纹
+	}
+	if clientOption.gospiderSpec != nil && clientOption.gospiderSpec.TLSSpec != nil {
+		return obj.utlsServer(ctx, conn, addr, !slices.Contains(nextProtos, "h2"), clientOption.gospiderSpec.TLSSpec, clientOption)
 	}
 	tlsConfig := obj.TlsConfig()
 	tlsConfig.NextProtos = nextProtos
 	tlsConfig.ServerName = gtls.GetServerName(addr)
+	if cert := obj.upstreamCert(addr); cert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*cert}
+	}
 	tlsConn := tls.Client(conn, tlsConfig)
 	if err := tlsConn.HandshakeContext(ctx); err != nil {
-		return tlsConn, "", err
+		return tlsConn, "", withStage(StageTlsHandshake, err)
+	}
+	state := tlsConn.ConnectionState()
+	return tlsConn, state.NegotiatedProtocol, obj.verifyServer(clientOption, tlsConfig.ServerName, state.PeerCertificates)
+}
+
+// 使用指纹连接服务端,forceHttp1 时alpn 改为http/1.1,无法生成握手消息时返回的连接为nil,可以换其它指纹重试
+func (obj *Client) utlsServer(ctx context.Context, conn net.Conn, addr string, forceHttp1 bool, spec *ja3.Spec, clientOption *ProxyOption) (net.Conn, string, error) {
+	utlsConfig := obj.UtlsConfig()
+	if cert := obj.upstreamCert(addr); cert != nil {
+		utlsConfig.Certificates = []utls.Certificate{utlsCertificate(cert)}
+	}
+	tlsConn, err := obj.specClient.Client(ctx, conn, spec, utlsConfig, gtls.GetServerName(addr), forceHttp1)
+	if tlsConn == nil {
+		return nil, "", withStage(StageTlsHandshake, err)
+	}
+	if err != nil {
+		return tlsConn, "", withStage(StageTlsHandshake, err)
 	}
-	return tlsConn, tlsConn.ConnectionState().NegotiatedProtocol, nil
+	state := tlsConn.ConnectionState()
+	return tlsConn, state.NegotiatedProtocol, obj.verifyServer(clientOption, gtls.GetServerName(addr), state.PeerCertificates)
 }
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// 错误发生的阶段
+type ErrorStage string
+
+const (
+	StageAccept       ErrorStage = "accept"        //接受连接
+	StageSniff        ErrorStage = "sniff"         //识别代理协议,读取首个请求
+	StageAuth         ErrorStage = "auth"          //认证,限流,流量配额
+	StageDial         ErrorStage = "dial"          //连接目标地址或上游代理
+	StageTlsHandshake ErrorStage = "tls-handshake" //tls 握手
+	StageVerify       ErrorStage = "verify"        //验证服务端证书
+	StageCopy         ErrorStage = "copy"          //转发数据
+	StagePanic        ErrorStage = "panic"         //处理连接时panic
+)
+
+// 带阶段的错误
+type StageError struct {
+	Stage ErrorStage
+	Err   error
+}
+
+func (obj *StageError) Error() string {
+	return fmt.Sprintf("%s: %v", obj.Stage, obj.Err)
+}
+func (obj *StageError) Unwrap() error {
+	return obj.Err
+}
+
+// 标记错误阶段,已经标记过的错误保持原阶段
+func withStage(stage ErrorStage, err error) error {
+	if err == nil {
+		return nil
+	}
+	var stageErr *StageError
+	if errors.As(err, &stageErr) {
+		return err
+	}
+	return &StageError{Stage: stage, Err: err}
+}
+
+// 获取错误阶段,没有标记的错误都发生在转发数据阶段
+func GetErrorStage(err error) ErrorStage {
+	var stageErr *StageError
+	if errors.As(err, &stageErr) {
+		return stageErr.Stage
+	}
+	return StageCopy
+}
+
+func panicError(r any) error {
+	return &StageError{Stage: StagePanic, Err: fmt.Errorf("%v\n%s", r, debug.Stack())}
+}
+
+// 连接内启动的协程使用,防止panic 导致进程退出
+func (obj *Client) recoverGoroutine(session *Session) {
+	if r := recover(); r != nil && obj.onError != nil {
+		obj.onError(session, StagePanic, panicError(r))
+	}
+}
@@ -3,6 +3,7 @@ module github.com/gospider007/proxy
 go 1.24.0
 
 require (
+	github.com/andybalholm/brotli v1.1.1
 	github.com/gospider007/gtls v0.0.0-20250324005721-d358b4cc74c6
 	github.com/gospider007/http2 v0.0.0-20250307152953-67c9f881b5be
 	github.com/gospider007/ja3 v0.0.0-20250309093815-ea9cc2528120
@@ -10,13 +11,15 @@ require (
 	github.com/gospider007/requests v0.0.0-20250320010644-8f3240c2e9d9
 	github.com/gospider007/tools v0.0.0-20250314001755-8fd6f4fc62e2
 	github.com/gospider007/websocket v0.0.0-20250306064730-90385d6147ad
+	github.com/klauspost/compress v1.18.0
 	github.com/refraction-networking/utls v1.6.7
+	golang.org/x/crypto v0.36.0
+	golang.org/x/net v0.37.0
 )
 
 require (
 	github.com/PuerkitoBio/goquery v1.10.2 // indirect
 	github.com/STARRY-S/zip v0.2.2 // indirect
-	github.com/andybalholm/brotli v1.1.1 // indirect
 	github.com/andybalholm/cascadia v1.3.3 // indirect
 	github.com/bodgit/plumbing v1.3.0 // indirect
 	github.com/bodgit/sevenzip v1.6.0 // indirect
@@ -43,7 +46,6 @@ require (
 	github.com/hashicorp/go-multierror v1.1.1 // indirect
 	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
-	github.com/klauspost/compress v1.18.0 // indirect
 	github.com/klauspost/cpuid/v2 v2.2.10 // indirect
 	github.com/klauspost/pgzip v1.2.6 // indirect
 	github.com/libdns/libdns v0.2.3 // indirect
@@ -72,11 +74,9 @@ require (
 	go.uber.org/zap v1.27.0 // indirect
 	go.uber.org/zap/exp v0.3.0 // indirect
 	go4.org v0.0.0-20230225012048-214862532bf5 // indirect
-	golang.org/x/crypto v0.36.0 // indirect
 	golang.org/x/exp v0.0.0-20250305212735-054e65f0b394 // indirect
 	golang.org/x/image v0.25.0 // indirect
 	golang.org/x/mod v0.24.0 // indirect
-	golang.org/x/net v0.37.0 // indirect
 	golang.org/x/sync v0.12.0 // indirect
 	golang.org/x/sys v0.31.0 // indirect
 	golang.org/x/text v0.23.0 // indirect
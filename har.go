@@ -0,0 +1,451 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// har 记录配置,Dir 和File 至少设置一个
+type HarOption struct {
+	Dir         string //每个会话保存一个har 文件到该目录
+	File        string //所有会话滚动保存到该文件,条数达到MaxEntries 后重命名为File.时间戳
+	MaxEntries  int    //滚动文件的最大条数,默认1000
+	MaxBodySize int    //记录body 的最大字节数,超过截断,默认1MB
+}
+
+type Har struct {
+	Log HarLog `json:"log"`
+}
+type HarLog struct {
+	Version string      `json:"version"`
+	Creator HarCreator  `json:"creator"`
+	Entries []*HarEntry `json:"entries"`
+}
+type HarCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+type HarEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HarRequest  `json:"request"`
+	Response        HarResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HarTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Tls             *HarTls     `json:"_tls,omitempty"`
+}
+type HarRequest struct {
+	Method      string         `json:"method"`
+	Url         string         `json:"url"`
+	HttpVersion string         `json:"httpVersion"`
+	Cookies     []HarCookie    `json:"cookies"`
+	Headers     []HarNameValue `json:"headers"`
+	QueryString []HarNameValue `json:"queryString"`
+	PostData    *HarPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+type HarResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HttpVersion string         `json:"httpVersion"`
+	Cookies     []HarCookie    `json:"cookies"`
+	Headers     []HarNameValue `json:"headers"`
+	Content     HarContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+type HarNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+type HarCookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	HttpOnly bool       `json:"httpOnly,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+}
+type HarPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"_encoding,omitempty"`
+}
+type HarContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+type HarTimings struct {
+	Blocked float64 `json:"blocked"`
+	Dns     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	Ssl     float64 `json:"ssl"`
+}
+type HarTls struct {
+	Version            string `json:"version"`
+	CipherSuite        string `json:"cipherSuite"`
+	ServerName         string `json:"serverName"`
+	NegotiatedProtocol string `json:"negotiatedProtocol"`
+}
+
+type harRecorder struct {
+	option  HarOption
+	lock    sync.Mutex
+	file    *os.File
+	entries int
+	closed  bool
+}
+
+func newHarRecorder(option HarOption) (*harRecorder, error) {
+	if option.Dir == "" && option.File == "" {
+		return nil, errors.New("har dir and file are empty")
+	}
+	if option.MaxEntries <= 0 {
+		option.MaxEntries = 1000
+	}
+	if option.MaxBodySize <= 0 {
+		option.MaxBodySize = 1024 * 1024
+	}
+	if option.Dir != "" {
+		if err := os.MkdirAll(option.Dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &harRecorder{option: option}, nil
+}
+
+func newHar(entries []*HarEntry) *Har {
+	return &Har{Log: HarLog{
+		Version: "1.2",
+		Creator: HarCreator{Name: "gospider007/proxy", Version: "1.0"},
+		Entries: entries,
+	}}
+}
+
+// 记录一个请求
+func (obj *harRecorder) add(session *Session, entry *HarEntry) error {
+	if obj.option.Dir != "" {
+		session.harLock.Lock()
+		session.harEntries = append(session.harEntries, entry)
+		session.harLock.Unlock()
+	}
+	if obj.option.File == "" {
+		return nil
+	}
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	if obj.closed {
+		return nil
+	}
+	if obj.file == nil {
+		if _, err = os.Stat(obj.option.File); err == nil { //保留上次的文件
+			if err = obj.rotate(); err != nil {
+				return err
+			}
+		}
+		if obj.file, err = os.Create(obj.option.File); err != nil {
+			return err
+		}
+		head, _ := json.Marshal(newHar(nil).Log.Creator)
+		if _, err = fmt.Fprintf(obj.file, `{"log":{"version":"1.2","creator":%s,"entries":[`, head); err != nil {
+			return err
+		}
+		obj.entries = 0
+	}
+	if obj.entries > 0 {
+		content = append([]byte{','}, content...)
+	}
+	if _, err = obj.file.Write(content); err != nil {
+		return err
+	}
+	obj.entries++
+	if obj.entries >= obj.option.MaxEntries {
+		if err = obj.closeFile(); err != nil {
+			return err
+		}
+		return obj.rotate()
+	}
+	return nil
+}
+func (obj *harRecorder) rotate() error {
+	return os.Rename(obj.option.File, fmt.Sprintf("%s.%d", obj.option.File, time.Now().UnixNano()))
+}
+func (obj *harRecorder) closeFile() error {
+	if obj.file == nil {
+		return nil
+	}
+	_, err := obj.file.WriteString("]}}")
+	if closeErr := obj.file.Close(); err == nil {
+		err = closeErr
+	}
+	obj.file = nil
+	return err
+}
+
+// 会话结束,保存会话的har 文件
+func (obj *harRecorder) closeSession(session *Session) error {
+	if obj.option.Dir == "" {
+		return nil
+	}
+	session.harLock.Lock()
+	entries := session.harEntries
+	session.harEntries = nil
+	session.harLock.Unlock()
+	if len(entries) == 0 {
+		return nil
+	}
+	content, err := json.Marshal(newHar(entries))
+	if err != nil {
+		return err
+	}
+	fileName := fmt.Sprintf("%d_%s.har", session.StartTime.UnixNano(), strings.NewReplacer(":", "_", "[", "", "]", "").Replace(session.ClientAddr.String()))
+	return os.WriteFile(filepath.Join(obj.option.Dir, fileName), content, 0644)
+}
+func (obj *harRecorder) Close() error {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.closed = true
+	return obj.closeFile()
+}
+
+// 记录body,超过最大长度只统计大小
+type harBody struct {
+	io.ReadCloser
+	max  int
+	buf  bytes.Buffer
+	size int64
+}
+
+func newHarBody(body io.ReadCloser, max int) *harBody {
+	return &harBody{ReadCloser: body, max: max}
+}
+func (obj *harBody) Read(p []byte) (int, error) {
+	n, err := obj.ReadCloser.Read(p)
+	obj.size += int64(n)
+	if remain := obj.max - obj.buf.Len(); remain > 0 {
+		obj.buf.Write(p[:min(n, remain)])
+	}
+	return n, err
+}
+func (obj *harBody) truncated() bool {
+	return obj.size > int64(obj.buf.Len())
+}
+
+// 按Content-Encoding 解码,截断的内容尽量解码
+func harDecode(content []byte, header http.Header) []byte {
+	decoded, err := decodeBody(content, contentEncodings(header))
+	if err != nil && len(decoded) == 0 {
+		return content
+	}
+	return decoded
+}
+func harIsText(mimeType string, content []byte) bool {
+	mediaType, _, _ := mime.ParseMediaType(mimeType)
+	if strings.HasPrefix(mediaType, "text/") ||
+		strings.Contains(mediaType, "json") ||
+		strings.Contains(mediaType, "xml") ||
+		strings.Contains(mediaType, "javascript") ||
+		mediaType == "application/x-www-form-urlencoded" {
+		return utf8.Valid(content)
+	}
+	return mediaType == "" && utf8.Valid(content)
+}
+func harText(mimeType string, content []byte) (string, string) {
+	if harIsText(mimeType, content) {
+		return string(content), ""
+	}
+	return base64.StdEncoding.EncodeToString(content), "base64"
+}
+func harHeaders(headers http.Header) []HarNameValue {
+	values := []HarNameValue{}
+	for name, vals := range headers {
+		for _, val := range vals {
+			values = append(values, HarNameValue{Name: name, Value: val})
+		}
+	}
+	return values
+}
+func harCookies(cookies []*http.Cookie) []HarCookie {
+	values := make([]HarCookie, len(cookies))
+	for i, cookie := range cookies {
+		values[i] = HarCookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Path:     cookie.Path,
+			Domain:   cookie.Domain,
+			HttpOnly: cookie.HttpOnly,
+			Secure:   cookie.Secure,
+		}
+		if !cookie.Expires.IsZero() {
+			values[i].Expires = &cookie.Expires
+		}
+	}
+	return values
+}
+func harDuration(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// 获取tls 连接信息
+func harTls(conn net.Conn) *HarTls {
+	switch tlsConn := conn.(type) {
+	case *tls.Conn:
+		state := tlsConn.ConnectionState()
+		return &HarTls{
+			Version:            tls.VersionName(state.Version),
+			CipherSuite:        tls.CipherSuiteName(state.CipherSuite),
+			ServerName:         state.ServerName,
+			NegotiatedProtocol: state.NegotiatedProtocol,
+		}
+	case *utls.UConn:
+		state := tlsConn.ConnectionState()
+		return &HarTls{
+			Version:            tls.VersionName(state.Version),
+			CipherSuite:        tls.CipherSuiteName(state.CipherSuite),
+			ServerName:         state.ServerName,
+			NegotiatedProtocol: state.NegotiatedProtocol,
+		}
+	}
+	return nil
+}
+
+// 一个请求的记录过程
+type harExchange struct {
+	maxBodySize int
+	startTime   time.Time
+	sendTime    time.Time
+	waitTime    time.Time
+	reqBody     *harBody
+	rspBody     *harBody
+}
+
+func (obj *harRecorder) newExchange(req *http.Request) *harExchange {
+	exchange := &harExchange{maxBodySize: obj.option.MaxBodySize, startTime: time.Now()}
+	if req.Body != nil && req.Body != http.NoBody {
+		exchange.reqBody = newHarBody(req.Body, exchange.maxBodySize)
+		req.Body = exchange.reqBody
+	}
+	return exchange
+}
+
+// 请求发送完成
+func (obj *harExchange) sent() {
+	if obj == nil {
+		return
+	}
+	obj.sendTime = time.Now()
+}
+
+// 收到响应头
+func (obj *harExchange) received(rsp *http.Response) {
+	if obj == nil {
+		return
+	}
+	obj.waitTime = time.Now()
+	if rsp.Body != nil && rsp.Body != http.NoBody {
+		obj.rspBody = newHarBody(rsp.Body, obj.maxBodySize)
+		rsp.Body = obj.rspBody
+	}
+}
+func (obj *harExchange) entry(server *ProxyConn, req *http.Request, rsp *http.Response) *HarEntry {
+	endTime := time.Now()
+	if obj.sendTime.IsZero() {
+		obj.sendTime = endTime
+	}
+	if obj.waitTime.IsZero() {
+		obj.waitTime = endTime
+	}
+	entry := &HarEntry{
+		StartedDateTime: obj.startTime,
+		Time:            harDuration(endTime.Sub(obj.startTime)),
+		Request: HarRequest{
+			Method:      req.Method,
+			Url:         req.URL.String(),
+			HttpVersion: req.Proto,
+			Cookies:     harCookies(req.Cookies()),
+			Headers:     harHeaders(req.Header),
+			QueryString: []HarNameValue{},
+			HeadersSize: -1,
+		},
+		Timings: HarTimings{
+			Blocked: -1,
+			Dns:     -1,
+			Connect: -1,
+			Ssl:     -1,
+			Send:    harDuration(obj.sendTime.Sub(obj.startTime)),
+			Wait:    harDuration(obj.waitTime.Sub(obj.sendTime)),
+			Receive: harDuration(endTime.Sub(obj.waitTime)),
+		},
+	}
+	if server != nil { //回放模式没有服务端连接
+		entry.Tls = harTls(server.conn)
+		if host, _, err := net.SplitHostPort(server.RemoteAddr().String()); err == nil {
+			entry.ServerIPAddress = host
+		}
+	}
+	for name, vals := range req.URL.Query() {
+		for _, val := range vals {
+			entry.Request.QueryString = append(entry.Request.QueryString, HarNameValue{Name: name, Value: val})
+		}
+	}
+	if obj.reqBody != nil {
+		entry.Request.BodySize = obj.reqBody.size
+		mimeType := req.Header.Get("Content-Type")
+		text, encoding := harText(mimeType, harDecode(obj.reqBody.buf.Bytes(), req.Header))
+		entry.Request.PostData = &HarPostData{MimeType: mimeType, Text: text, Encoding: encoding}
+	}
+	if rsp == nil {
+		entry.Response = HarResponse{Cookies: []HarCookie{}, Headers: []HarNameValue{}, HeadersSize: -1, BodySize: -1}
+		return entry
+	}
+	entry.Response = HarResponse{
+		Status:      rsp.StatusCode,
+		StatusText:  http.StatusText(rsp.StatusCode),
+		HttpVersion: rsp.Proto,
+		Cookies:     harCookies(rsp.Cookies()),
+		Headers:     harHeaders(rsp.Header),
+		RedirectURL: rsp.Header.Get("Location"),
+		HeadersSize: -1,
+		Content:     HarContent{MimeType: rsp.Header.Get("Content-Type")},
+	}
+	if obj.rspBody != nil {
+		entry.Response.BodySize = obj.rspBody.size
+		content := harDecode(obj.rspBody.buf.Bytes(), rsp.Header)
+		entry.Response.Content.Size = int64(len(content))
+		entry.Response.Content.Text, entry.Response.Content.Encoding = harText(entry.Response.Content.MimeType, content)
+		if obj.rspBody.truncated() {
+			entry.Response.Content.Comment = fmt.Sprintf("body truncated, %d bytes received", obj.rspBody.size)
+		}
+	}
+	return entry
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// 请求头修改方式
+type HeaderAction int
+
+const (
+	HeaderSet     HeaderAction = iota + 1 //设置,覆盖已有的值
+	HeaderAdd                             //追加
+	HeaderDel                             //删除
+	HeaderReplace                         //正则替换已有的值
+)
+
+// 请求头修改规则,按顺序执行所有匹配的规则
+type HeaderRule struct {
+	Match    UrlMatch
+	Response bool //修改响应头,默认修改请求头
+	Action   HeaderAction
+	Name     string //请求头名称,Host 修改请求的Host
+	Value    string //Set,Add 的值,Replace 的替换内容,支持$1
+	Regexp   string //Replace 匹配值的正则
+}
+
+type headerRule struct {
+	HeaderRule
+	re *regexp.Regexp
+}
+
+func newHeaderRules(rules []HeaderRule) ([]headerRule, error) {
+	headerRules := make([]headerRule, len(rules))
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return nil, errors.New("header rule name is empty")
+		}
+		rule.Name = http.CanonicalHeaderKey(rule.Name)
+		headerRules[i].HeaderRule = rule
+		switch rule.Action {
+		case HeaderSet, HeaderAdd, HeaderDel:
+		case HeaderReplace:
+			re, err := regexp.Compile(rule.Regexp)
+			if err != nil {
+				return nil, err
+			}
+			headerRules[i].re = re
+		default:
+			return nil, errors.New("header rule action error")
+		}
+	}
+	return headerRules, nil
+}
+func (obj *headerRule) apply(header http.Header) {
+	switch obj.Action {
+	case HeaderSet:
+		header.Set(obj.Name, obj.Value)
+	case HeaderAdd:
+		header.Add(obj.Name, obj.Value)
+	case HeaderDel:
+		header.Del(obj.Name)
+	case HeaderReplace:
+		for i, value := range header[obj.Name] {
+			header[obj.Name][i] = obj.re.ReplaceAllString(value, obj.Value)
+		}
+	}
+}
+
+// 修改请求头,Host 写在req.Host
+func (obj *Client) rewriteRequestHeader(req *http.Request) {
+	for i := range obj.headerRules {
+		rule := &obj.headerRules[i]
+		if rule.Response || !rule.Match.match(req) {
+			continue
+		}
+		if rule.Name == "Host" {
+			header := http.Header{"Host": []string{req.Host}}
+			rule.apply(header)
+			req.Host = header.Get("Host")
+			continue
+		}
+		rule.apply(req.Header)
+	}
+}
+
+// 修改响应头
+func (obj *Client) rewriteResponseHeader(req *http.Request, rsp *http.Response) {
+	for i := range obj.headerRules {
+		rule := &obj.headerRules[i]
+		if rule.Response && rule.Match.match(req) {
+			rule.apply(rsp.Header)
+		}
+	}
+}
+
+// 逐跳头,转发时删除
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// 删除逐跳头和Connection 中列出的头,保留websocket 等协议升级
+func removeHopHeaders(header http.Header) {
+	upgrade := ""
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); strings.EqualFold(name, "upgrade") {
+				upgrade = header.Get("Upgrade")
+			} else if name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+	if upgrade != "" {
+		header.Set("Connection", "Upgrade")
+		header.Set("Upgrade", upgrade)
+	}
+}
+
+// 转发请求前删除逐跳头,按配置添加Via,X-Forwarded-For,Forwarded
+func (obj *Client) forwardRequestHeader(client *ProxyConn, req *http.Request) {
+	removeHopHeaders(req.Header)
+	if obj.via != "" {
+		req.Header.Add("Via", fmt.Sprintf("%d.%d %s", req.ProtoMajor, req.ProtoMinor, obj.via))
+	}
+	if !obj.xForwardedFor && !obj.forwarded {
+		return
+	}
+	clientIp, _, err := net.SplitHostPort(client.option.session.ClientAddr.String())
+	if err != nil {
+		return
+	}
+	if obj.xForwardedFor { //合并客户端发送的多个X-Forwarded-For
+		req.Header.Set("X-Forwarded-For", strings.Join(append(req.Header.Values("X-Forwarded-For"), clientIp), ", "))
+	}
+	if obj.forwarded {
+		if strings.Contains(clientIp, ":") {
+			clientIp = "[" + clientIp + "]"
+		}
+		req.Header.Add("Forwarded", fmt.Sprintf("for=%s;host=%s;proto=%s", forwardedValue(clientIp), forwardedValue(req.Host), req.URL.Scheme))
+	}
+}
+
+// Forwarded 的值,不是token 时加引号,如ipv6,带端口的host
+func forwardedValue(value string) string {
+	if value == "" {
+		return `""`
+	}
+	for _, c := range value {
+		if !strings.ContainsRune("!#$%&'*+-.^_`|~", c) && !isAlphanumeric(byte(c)) || c > 0x7f {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+		}
+	}
+	return value
+}
@@ -5,7 +5,6 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
-	"net"
 	"net/http"
 
 	"github.com/gospider007/requests"
@@ -25,63 +24,103 @@ func (obj *Client) httpHandle(ctx context.Context, client *ProxyConn) error {
 	if err != nil {
 		return err
 	}
-	proxyUrl, err := obj.GetProxy(ctx, clientReq.URL)
-	if err != nil {
-		return err
+	if isCaRequest(clientReq) {
+		return obj.serveCa(client, clientReq)
 	}
-	var proxyServer net.Conn
-	addr, err := requests.GetAddressWithUrl(clientReq.URL)
-	if err != nil {
-		return err
+	client.option.session.Method = clientReq.Method
+	client.option.session.Url = clientReq.URL.String()
+	client.option.session.Host = clientReq.URL.Host
+	if err = obj.verifyTraffic(client); err != nil {
+		client.Write([]byte(fmt.Sprintf("%s 403 Forbidden\r\nContent-Length: 0\r\n\r\n", clientReq.Proto)))
+		return withStage(StageAuth, err)
 	}
-	if proxyUrl != nil {
-		proxyAddress, err := requests.GetAddressWithUrl(proxyUrl)
-		if err != nil {
-			return err
-		}
-		remoteAddress, err := requests.GetAddressWithUrl(clientReq.URL)
-		if err != nil {
-			return err
-		}
-		remoteAddress.Scheme = client.option.schema
-		if _, proxyServer, err = obj.dialer.DialProxyContext(requests.NewResponse(ctx, requests.RequestOption{}), "tcp", obj.TlsConfig(), proxyAddress, remoteAddress); err != nil {
-			return err
-		}
-	} else {
-		if proxyServer, err = obj.dialer.DialContext(requests.NewResponse(ctx, requests.RequestOption{}), "tcp", addr); err != nil {
-			return err
-		}
+	release, err := obj.acquireLimit(client)
+	if err != nil {
+		client.Write([]byte(fmt.Sprintf("%s 429 Too Many Requests\r\nContent-Length: 0\r\n\r\n", clientReq.Proto)))
+		return withStage(StageAuth, err)
 	}
-	server := newProxyCon(proxyServer, bufio.NewReader(proxyServer), *client.option, false)
-	defer server.Close()
+	defer release()
+	client.option.forward = clientReq.Method != http.MethodConnect
 	if client.option.schema == "https" {
 		if obj.createSpecWithHttp != nil {
 			spec := obj.createSpecWithHttp(clientReq)
 			if spec != nil {
 				client.option.gospiderSpec = spec
 			} else {
-				client.option.gospiderSpec = obj.gospiderSpec
+				client.option.gospiderSpec = obj.selectSpec(client.option.session, clientReq.URL.Host)
 			}
 		} else {
-			client.option.gospiderSpec = obj.gospiderSpec
+			client.option.gospiderSpec = obj.selectSpec(client.option.session, clientReq.URL.Host)
 		}
 	}
-	if clientReq.Method == http.MethodConnect {
-		if _, err = client.Write([]byte(fmt.Sprintf("%s 200 Connection established\r\n\r\n", clientReq.Proto))); err != nil {
-			return err
+	if clientReq.Method != http.MethodConnect || ((obj.archive.replaying() || obj.mapHost(clientReq.URL.Host)) && obj.mitm.intercept(clientReq.URL.Host)) {
+		if clientReq.Method == http.MethodConnect {
+			if _, err = client.Write([]byte(fmt.Sprintf("%s 200 Connection established\r\n\r\n", clientReq.Proto))); err != nil {
+				return err
+			}
+		} else {
+			client.req = clientReq
 		}
-	} else {
-		client.req = clientReq
+		return obj.lazyMain(ctx, client)
+	}
+	proxyUrl, err := obj.GetProxy(ctx, clientReq.URL)
+	if err != nil {
+		return withStage(StageDial, err)
+	}
+	remoteAddress, err := requests.GetAddressWithUrl(clientReq.URL)
+	if err != nil {
+		return withStage(StageDial, err)
+	}
+	remoteAddress.Scheme = client.option.schema
+	proxyServer, err := obj.dialServer(ctx, client, clientReq.URL, proxyUrl, remoteAddress)
+	if err != nil {
+		return err
+	}
+	server := newProxyCon(proxyServer, bufio.NewReader(proxyServer), *client.option, false)
+	server.target = clientReq.URL.Host
+	defer server.Close()
+	if _, err = client.Write([]byte(fmt.Sprintf("%s 200 Connection established\r\n\r\n", clientReq.Proto))); err != nil {
+		return err
 	}
 	return obj.copyMain(ctx, client, server)
 }
+
+// 同一个连接的http 代理请求改变目标地址时,重新执行connect 回调和认证,更新会话
+func (obj *Client) verifyRequest(client *ProxyConn, req *http.Request) error {
+	session := client.option.session
+	session.Method = req.Method
+	session.Url = req.URL.String()
+	session.Host = req.URL.Host
+	if obj.httpConnectCallBack != nil {
+		if err := obj.httpConnectCallBack(req); err != nil {
+			return err
+		}
+	}
+	if obj.verifyAuthWithHttp != nil {
+		if err := obj.verifyAuthWithHttp(req); err != nil {
+			return withStage(StageAuth, err)
+		}
+	} else if client.option.certUser == "" {
+		if err := obj.verifyPwd(client, req); err != nil {
+			return withStage(StageAuth, err)
+		}
+	}
+	return nil
+}
 func (obj *Client) httpsHandle(ctx context.Context, client *ProxyConn) error {
 	defer client.Close()
 	tlsClient := tls.Server(client, obj.ProxyTlsConfig())
 	defer tlsClient.Close()
 	if err := tlsClient.HandshakeContext(ctx); err != nil {
-
-		return err
+		return withStage(StageTlsHandshake, err)
+	}
+	usr, err := obj.clientCertUser(tlsClient.ConnectionState())
+	if err != nil {
+		return withStage(StageAuth, err)
+	}
+	if usr != "" { //客户端证书认证
+		client.option.certUser = usr
+		client.option.session.User = usr
 	}
-	return obj.httpHandle(ctx, newProxyCon(tlsClient, bufio.NewReader(tlsClient), *client.option, true))
+	return obj.httpHandle(ctx, newProxyCon(tlsClient, bufio.NewReaderSize(tlsClient, clientReaderSize), *client.option, true))
 }
@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// 限流配置,按客户端ip或认证用户分别计算,0 表示不限制
+type LimitOption struct {
+	ConnRate  float64 //每秒新建连接数
+	ConnBurst int     //新建连接突发数,默认为ConnRate 向上取整
+	MaxConns  int     //最大并发连接数
+	UpRate    int     //上行(客户端发往目标地址)每秒字节数
+	DownRate  int     //下行(目标地址发往客户端)每秒字节数
+	ByUser    bool    //有认证用户时按用户限流,否则按客户端ip
+}
+
+var errLimit = errors.New("limit exceeded")
+
+// 令牌桶,允许透支,透支部分通过等待偿还
+type tokenBucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = math.Max(1, math.Ceil(rate))
+	}
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+func (obj *tokenBucket) refill(now time.Time) {
+	obj.tokens = math.Min(obj.burst, obj.tokens+now.Sub(obj.last).Seconds()*obj.rate)
+	obj.last = now
+}
+
+// 取一个令牌,没有令牌返回false
+func (obj *tokenBucket) allow() bool {
+	if obj == nil {
+		return true
+	}
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.refill(time.Now())
+	if obj.tokens < 1 {
+		return false
+	}
+	obj.tokens--
+	return true
+}
+
+// 取n 个令牌,不足时阻塞到令牌补齐,ctx 结束时返回错误
+func (obj *tokenBucket) wait(ctx context.Context, n int) error {
+	if obj == nil || n <= 0 {
+		return nil
+	}
+	obj.lock.Lock()
+	obj.refill(time.Now())
+	obj.tokens -= float64(n)
+	tokens := obj.tokens
+	obj.lock.Unlock()
+	if tokens >= 0 {
+		return nil
+	}
+	timer := time.NewTimer(time.Duration(-tokens / obj.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-timer.C:
+		return nil
+	}
+}
+func (obj *tokenBucket) full(now time.Time) bool {
+	if obj == nil {
+		return true
+	}
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.refill(now)
+	return obj.tokens >= obj.burst
+}
+
+type limitEntry struct {
+	conns    int
+	lastTime time.Time
+	conn     *tokenBucket
+	up       *tokenBucket
+	down     *tokenBucket
+}
+
+type limiter struct {
+	option    LimitOption
+	lock      sync.Mutex
+	entrys    map[string]*limitEntry
+	pruneTime time.Time
+}
+
+func newLimiter(option LimitOption) *limiter {
+	return &limiter{option: option, entrys: make(map[string]*limitEntry), pruneTime: time.Now()}
+}
+
+// 占用一个连接,超过限制返回errLimit
+func (obj *limiter) acquire(key string) (*limitEntry, error) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	now := time.Now()
+	if now.Sub(obj.pruneTime) > time.Minute {
+		obj.prune(now)
+	}
+	entry, ok := obj.entrys[key]
+	if !ok {
+		entry = &limitEntry{
+			conn: newTokenBucket(obj.option.ConnRate, float64(obj.option.ConnBurst)),
+			up:   newTokenBucket(float64(obj.option.UpRate), 0),
+			down: newTokenBucket(float64(obj.option.DownRate), 0),
+		}
+		obj.entrys[key] = entry
+	}
+	entry.lastTime = now
+	if obj.option.MaxConns > 0 && entry.conns >= obj.option.MaxConns {
+		return nil, errLimit
+	}
+	if !entry.conn.allow() {
+		return nil, errLimit
+	}
+	entry.conns++
+	return entry, nil
+}
+func (obj *limiter) release(entry *limitEntry) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	entry.conns--
+	entry.lastTime = time.Now()
+}
+
+// 清理空闲且令牌已满的记录,删除后重建不会放宽限制
+func (obj *limiter) prune(now time.Time) {
+	obj.pruneTime = now
+	for key, entry := range obj.entrys {
+		if entry.conns == 0 && now.Sub(entry.lastTime) > time.Minute && entry.conn.full(now) && entry.up.full(now) && entry.down.full(now) {
+			delete(obj.entrys, key)
+		}
+	}
+}
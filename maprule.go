@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// 请求匹配条件,为空的字段匹配所有,支持通配符*
+type UrlMatch struct {
+	Host   string //如 *.example.com,不带端口时忽略端口
+	Path   string //如 /api/*
+	Method string //如 GET
+}
+
+// 通配符匹配,*匹配任意字符
+func globMatch(pattern string, value string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == value
+	}
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(value, part)
+		if i < 0 {
+			return false
+		}
+		value = value[i+len(part):]
+	}
+	return strings.HasSuffix(value, parts[len(parts)-1])
+}
+func (obj UrlMatch) matchHost(hostPort string) bool {
+	if obj.Host == "" {
+		return true
+	}
+	if !strings.Contains(obj.Host, ":") {
+		if host, _, err := net.SplitHostPort(hostPort); err == nil {
+			hostPort = host
+		}
+	}
+	return globMatch(strings.ToLower(obj.Host), strings.ToLower(hostPort))
+}
+func (obj UrlMatch) match(req *http.Request) bool {
+	if obj.Method != "" && !strings.EqualFold(obj.Method, req.Method) {
+		return false
+	}
+	return obj.matchHost(req.URL.Host) && globMatch(obj.Path, req.URL.Path)
+}
+
+// 去掉Path 通配符前的前缀,返回剩余的路径
+func (obj UrlMatch) rest(urlPath string) string {
+	prefix, _, _ := strings.Cut(obj.Path, "*")
+	return strings.TrimPrefix(urlPath, prefix)
+}
+
+// 本地映射,匹配的请求不转发,返回本地文件
+type MapLocal struct {
+	Match UrlMatch
+	Path  string //本地文件或目录,目录时按请求路径去掉Match.Path 通配符前的前缀查找文件
+}
+
+func (obj *MapLocal) response(req *http.Request) *http.Response {
+	filePath := obj.Path
+	if info, err := os.Stat(filePath); err == nil && info.IsDir() {
+		rest := path.Clean("/" + obj.Match.rest(req.URL.Path))
+		if rest == "/" {
+			rest = "/index.html"
+		}
+		filePath = filepath.Join(filePath, filepath.FromSlash(rest))
+	}
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return NewResponse(req, http.StatusNotFound, nil, nil)
+	}
+	header := make(http.Header)
+	if contentType := mime.TypeByExtension(filepath.Ext(filePath)); contentType != "" {
+		header.Set("Content-Type", contentType)
+	} else {
+		header.Set("Content-Type", http.DetectContentType(content))
+	}
+	return NewResponse(req, http.StatusOK, header, content)
+}
+
+// 远程映射,匹配的请求改写到另一个地址
+type MapRemote struct {
+	Match UrlMatch
+	To    string //目标地址,如 https://api.example.com/v2,为空的部分保留原请求,Match.Path 以*结尾时剩余路径追加到目标路径后
+}
+
+type mapRemoteRule struct {
+	MapRemote
+	to *url.URL
+}
+
+func newMapRemoteRules(rules []MapRemote) ([]mapRemoteRule, error) {
+	mapRemoteRules := make([]mapRemoteRule, len(rules))
+	for i, rule := range rules {
+		if rule.To == "" {
+			return nil, errors.New("map remote to is empty")
+		}
+		to, err := url.Parse(rule.To)
+		if err != nil {
+			return nil, err
+		}
+		if to.Scheme != "" && to.Scheme != "http" && to.Scheme != "https" {
+			return nil, errors.New("map remote scheme error: " + to.Scheme)
+		}
+		mapRemoteRules[i] = mapRemoteRule{MapRemote: rule, to: to}
+	}
+	return mapRemoteRules, nil
+}
+
+func (obj *mapRemoteRule) apply(req *http.Request) {
+	to := obj.to
+	if to.Scheme != "" {
+		req.URL.Scheme = to.Scheme
+	}
+	if to.Host != "" {
+		req.Host = to.Host
+		if to.Port() == "" {
+			if req.URL.Scheme == "https" {
+				req.URL.Host = to.Host + ":443"
+			} else {
+				req.URL.Host = to.Host + ":80"
+			}
+		} else {
+			req.URL.Host = to.Host
+		}
+	}
+	if to.Path != "" {
+		if strings.HasSuffix(obj.Match.Path, "*") {
+			req.URL.Path = strings.TrimSuffix(to.Path, "/") + "/" + strings.TrimPrefix(obj.Match.rest(req.URL.Path), "/")
+		} else {
+			req.URL.Path = to.Path
+		}
+		req.URL.RawPath = ""
+	}
+	if to.RawQuery != "" {
+		req.URL.RawQuery = to.RawQuery
+	}
+}
+
+// 匹配的本地映射
+func (obj *Client) mapLocalRule(req *http.Request) *MapLocal {
+	for i := range obj.mapLocal {
+		if obj.mapLocal[i].Match.match(req) {
+			return &obj.mapLocal[i]
+		}
+	}
+	return nil
+}
+
+// 应用第一个匹配的远程映射,返回是否改写
+func (obj *Client) remapRequest(req *http.Request) bool {
+	for i := range obj.mapRemote {
+		if obj.mapRemote[i].Match.match(req) {
+			obj.mapRemote[i].apply(req)
+			return true
+		}
+	}
+	return false
+}
+
+// connect 的目标地址是否有映射规则,有则中间人解密后按请求处理
+func (obj *Client) mapHost(hostPort string) bool {
+	for _, rule := range obj.mapLocal {
+		if rule.Match.matchHost(hostPort) {
+			return true
+		}
+	}
+	for _, rule := range obj.mapRemote {
+		if rule.Match.matchHost(hostPort) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+)
+
+// 中间件返回ErrDrop 时直接断开客户端连接,不返回响应
+var ErrDrop = errors.New("connection dropped by middleware")
+
+// http 请求中间件,按顺序执行OnRequest,按相反顺序执行OnResponse
+type Middleware interface {
+	//转发请求前调用,返回响应则不再转发,直接返回给客户端
+	OnRequest(req *http.Request) (*http.Response, error)
+	//返回给客户端前调用,可以修改响应
+	OnResponse(req *http.Request, rsp *http.Response) error
+}
+
+// 函数中间件,为空的函数跳过
+type MiddlewareFunc struct {
+	Request  func(req *http.Request) (*http.Response, error)
+	Response func(req *http.Request, rsp *http.Response) error
+}
+
+func (obj MiddlewareFunc) OnRequest(req *http.Request) (*http.Response, error) {
+	if obj.Request == nil {
+		return nil, nil
+	}
+	return obj.Request(req)
+}
+func (obj MiddlewareFunc) OnResponse(req *http.Request, rsp *http.Response) error {
+	if obj.Response == nil {
+		return nil
+	}
+	return obj.Response(req, rsp)
+}
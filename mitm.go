@@ -0,0 +1,260 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 中间人解密范围,不解密的https 连接直接转发
+type MitmOption struct {
+	//需要解密的地址,为空时全部解密,支持域名通配符*.example.com,正则re:^api\.,ip,网段10.0.0.0/8
+	Include []string
+	//不解密的地址,优先级高于Include,格式同Include
+	Exclude []string
+	//同一个域名客户端连续拒绝证书的次数,达到后不再解密这个域名,默认3,小于0 时关闭
+	FailThreshold int
+	//自动跳过解密的时长,过期后重新尝试解密,默认1小时
+	PassthroughTime time.Duration
+	//自动跳过解密的最多域名数,超过时淘汰最早过期的,默认10000
+	MaxPassthrough int
+}
+
+type mitmRule struct {
+	glob string
+	re   *regexp.Regexp
+	cidr *net.IPNet
+}
+
+func newMitmRules(patterns []string) ([]mitmRule, error) {
+	rules := make([]mitmRule, len(patterns))
+	for i, pattern := range patterns {
+		if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, err
+			}
+			rules[i].re = re
+		} else if _, cidr, err := net.ParseCIDR(pattern); err == nil {
+			rules[i].cidr = cidr
+		} else {
+			rules[i].glob = strings.ToLower(pattern)
+		}
+	}
+	return rules, nil
+}
+
+func (obj mitmRule) match(host string) bool {
+	if obj.re != nil {
+		return obj.re.MatchString(host)
+	}
+	if obj.cidr != nil {
+		ip := net.ParseIP(host)
+		return ip != nil && obj.cidr.Contains(ip)
+	}
+	return globMatch(obj.glob, host)
+}
+
+func matchMitmRules(rules []mitmRule, hosts []string) bool {
+	for _, rule := range rules {
+		for _, host := range hosts {
+			if rule.match(host) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 按域名决定是否中间人解密,记录客户端拒绝证书的域名
+type mitmFilter struct {
+	include         []mitmRule
+	exclude         []mitmRule
+	threshold       int
+	passthroughTime time.Duration
+	maxPassthrough  int
+	lock            sync.Mutex
+	fails           map[string]int
+	passthrough     map[string]time.Time //跳过解密的过期时间
+}
+
+func newMitmFilter(option MitmOption) (*mitmFilter, error) {
+	include, err := newMitmRules(option.Include)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := newMitmRules(option.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	if option.FailThreshold == 0 {
+		option.FailThreshold = 3
+	}
+	if option.PassthroughTime <= 0 {
+		option.PassthroughTime = time.Hour
+	}
+	if option.MaxPassthrough <= 0 {
+		option.MaxPassthrough = 10000
+	}
+	return &mitmFilter{
+		include:         include,
+		exclude:         exclude,
+		threshold:       option.FailThreshold,
+		passthroughTime: option.PassthroughTime,
+		maxPassthrough:  option.MaxPassthrough,
+		fails:           make(map[string]int),
+		passthrough:     make(map[string]time.Time),
+	}, nil
+}
+
+// 去掉端口,转小写
+func mitmHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+// 是否解密,hosts 为connect 地址和sni
+func (obj *mitmFilter) intercept(hosts ...string) bool {
+	names := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if host = mitmHost(host); host != "" && !slices.Contains(names, host) {
+			names = append(names, host)
+		}
+	}
+	if matchMitmRules(obj.exclude, names) {
+		return false
+	}
+	if len(obj.include) > 0 && !matchMitmRules(obj.include, names) {
+		return false
+	}
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	now := time.Now()
+	for _, name := range names {
+		if expire, ok := obj.passthrough[name]; ok {
+			if now.Before(expire) {
+				return false
+			}
+			delete(obj.passthrough, name)
+		}
+	}
+	return true
+}
+
+// 客户端不信任证书时发送的tls 警告
+var certAlerts = []tls.AlertError{42, 43, 44, 45, 46, 48} //bad_certificate,unsupported_certificate,certificate_revoked,certificate_expired,certificate_unknown,unknown_ca
+
+// 是否是客户端拒绝证书的错误,客户端关闭连接,超时等错误不算
+func isCertAlert(err error) bool {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "remote error" || opErr.Err == nil {
+		return false
+	}
+	for _, alert := range certAlerts {
+		if opErr.Err.Error() == alert.Error() {
+			return true
+		}
+	}
+	return false
+}
+
+// 记录客户端握手结果,连续拒绝证书达到次数后一段时间内不再解密
+func (obj *mitmFilter) handshake(host string, err error) {
+	if obj.threshold < 0 {
+		return
+	}
+	host = mitmHost(host)
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	if err == nil {
+		delete(obj.fails, host)
+		return
+	}
+	if !isCertAlert(err) {
+		return
+	}
+	if _, ok := obj.fails[host]; !ok && len(obj.fails) >= obj.maxPassthrough {
+		clear(obj.fails)
+	}
+	obj.fails[host]++
+	if obj.fails[host] < obj.threshold {
+		return
+	}
+	delete(obj.fails, host)
+	if _, ok := obj.passthrough[host]; !ok && len(obj.passthrough) >= obj.maxPassthrough {
+		obj.evict()
+	}
+	obj.passthrough[host] = time.Now().Add(obj.passthroughTime)
+}
+
+// 删除过期的域名,没有过期的删除最早过期的
+func (obj *mitmFilter) evict() {
+	now := time.Now()
+	var oldest string
+	for host, expire := range obj.passthrough {
+		if !now.Before(expire) {
+			delete(obj.passthrough, host)
+		} else if oldest == "" || expire.Before(obj.passthrough[oldest]) {
+			oldest = host
+		}
+	}
+	if oldest != "" && len(obj.passthrough) >= obj.maxPassthrough {
+		delete(obj.passthrough, oldest)
+	}
+}
+
+func (obj *mitmFilter) hosts() []string {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	now := time.Now()
+	hosts := make([]string, 0, len(obj.passthrough))
+	for host, expire := range obj.passthrough {
+		if now.Before(expire) {
+			hosts = append(hosts, host)
+		}
+	}
+	slices.Sort(hosts)
+	return hosts
+}
+
+// 清除自动跳过解密的域名,为空时清除全部
+func (obj *mitmFilter) reset(hosts ...string) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	if len(hosts) == 0 {
+		clear(obj.passthrough)
+		clear(obj.fails)
+		return
+	}
+	for _, host := range hosts {
+		host = mitmHost(host)
+		delete(obj.passthrough, host)
+		delete(obj.fails, host)
+	}
+}
+
+// 客户端拒绝证书后自动跳过解密的域名
+func (obj *Client) PassthroughHosts() []string {
+	return obj.mitm.hosts()
+}
+
+// 重新解密自动跳过解密的域名,为空时全部重新解密
+func (obj *Client) ResetPassthrough(hosts ...string) {
+	obj.mitm.reset(hosts...)
+}
+
+// connect 的tls 连接是否中间人解密
+func (obj *Client) mitmClient(client *ProxyConn) bool {
+	var serverName string
+	if hello, err := peekClientHello(client.reader); err == nil {
+		serverName = hello.ServerName
+	}
+	return obj.mitm.intercept(client.option.host, serverName)
+}
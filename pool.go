@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gospider007/requests"
+)
+
+// 服务端连接池配置
+type PoolOption struct {
+	MaxIdlePerHost int           //每个目标地址的最大空闲连接数,默认2,小于0 时不复用连接
+	MaxIdle        int           //总的最大空闲连接数,默认100
+	IdleTimeout    time.Duration //空闲连接超时时间,默认90秒
+}
+
+type poolConn struct {
+	conn *ProxyConn
+	time time.Time
+}
+
+// 服务端连接池,按目标地址,上游代理,指纹区分
+type connPool struct {
+	option PoolOption
+	lock   sync.Mutex
+	idle   map[string][]*poolConn
+	total  int
+}
+
+func newConnPool(option PoolOption) *connPool {
+	if option.MaxIdlePerHost < 0 {
+		return nil
+	}
+	if option.MaxIdlePerHost == 0 {
+		option.MaxIdlePerHost = 2
+	}
+	if option.MaxIdle <= 0 {
+		option.MaxIdle = 100
+	}
+	if option.IdleTimeout <= 0 {
+		option.IdleTimeout = time.Second * 90
+	}
+	return &connPool{option: option, idle: make(map[string][]*poolConn)}
+}
+
+// 连接池的key,返回空时不复用连接
+func poolKey(href *url.URL, proxyUrl *url.URL, spec *requests.GospiderSpec) string {
+	key := href.Scheme + "://" + href.Host
+	if proxyUrl != nil {
+		key += "|" + proxyUrl.String()
+	}
+	if spec != nil {
+		key += fmt.Sprintf("|%p", spec)
+	}
+	return key
+}
+
+// 取出最近放入的可用连接
+func (obj *connPool) get(key string) *ProxyConn {
+	if obj == nil || key == "" {
+		return nil
+	}
+	for {
+		obj.lock.Lock()
+		conns := obj.idle[key]
+		if len(conns) == 0 {
+			obj.lock.Unlock()
+			return nil
+		}
+		pc := conns[len(conns)-1]
+		if len(conns) == 1 {
+			delete(obj.idle, key)
+		} else {
+			obj.idle[key] = conns[:len(conns)-1]
+		}
+		obj.total--
+		obj.lock.Unlock()
+		if time.Since(pc.time) < obj.option.IdleTimeout && pc.conn.alive() {
+			return pc.conn
+		}
+		pc.conn.Close()
+	}
+}
+
+// 放回连接,超过限制时关闭最早的空闲连接
+func (obj *connPool) put(conn *ProxyConn) {
+	if obj == nil || conn.poolKey == "" {
+		conn.Close()
+		return
+	}
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.prune()
+	conns := obj.idle[conn.poolKey]
+	if len(conns) >= obj.option.MaxIdlePerHost {
+		conns[0].conn.Close()
+		conns = conns[1:]
+		obj.total--
+	}
+	if obj.total >= obj.option.MaxIdle {
+		obj.removeOldest()
+	}
+	obj.idle[conn.poolKey] = append(conns, &poolConn{conn: conn, time: time.Now()})
+	obj.total++
+}
+
+// 关闭超时的空闲连接
+func (obj *connPool) prune() {
+	for key, conns := range obj.idle {
+		i := 0
+		for i < len(conns) && time.Since(conns[i].time) >= obj.option.IdleTimeout {
+			conns[i].conn.Close()
+			i++
+		}
+		obj.total -= i
+		if i == len(conns) {
+			delete(obj.idle, key)
+		} else if i > 0 {
+			obj.idle[key] = conns[i:]
+		}
+	}
+}
+func (obj *connPool) removeOldest() {
+	var oldestKey string
+	var oldest *poolConn
+	for key, conns := range obj.idle {
+		if oldest == nil || conns[0].time.Before(oldest.time) {
+			oldestKey, oldest = key, conns[0]
+		}
+	}
+	if oldest == nil {
+		return
+	}
+	oldest.conn.Close()
+	if conns := obj.idle[oldestKey]; len(conns) == 1 {
+		delete(obj.idle, oldestKey)
+	} else {
+		obj.idle[oldestKey] = conns[1:]
+	}
+	obj.total--
+}
+
+// 关闭所有空闲连接
+func (obj *connPool) Close() {
+	if obj == nil {
+		return
+	}
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	for _, conns := range obj.idle {
+		for _, pc := range conns {
+			pc.conn.Close()
+		}
+	}
+	obj.idle = make(map[string][]*poolConn)
+	obj.total = 0
+}
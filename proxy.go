@@ -7,12 +7,14 @@ import (
 	_ "embed"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/url"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"net/http"
@@ -34,10 +36,22 @@ type ClientOption struct {
 	CrtFile     []byte //公钥,根证书
 	KeyFile     []byte //私钥
 	DomainNames []string
+	CaCrt       []byte //中间人根证书PEM,和CaKey 一起设置,默认第一次中间人解密或下载根证书时使用DefaultCaDir 目录的根证书
+	CaKey       []byte //中间人根证书私钥PEM
+	CaDir       string //中间人根证书目录,加载ca.crt,ca.key,不存在时生成并保存,客户端可以从监听地址的/ca.crt 下载安装
+
+	CertCache CertCacheOption //中间人签发证书的缓存,可以签发通配符证书,设置私钥类型
+	Mitm      MitmOption      //中间人解密的范围,按域名,正则,网段选择解密或直接转发
+
+	//代理监听tls 时验证客户端证书,证书映射为用户,可以代替账号密码认证
+	ClientCert *ClientCertOption
 
 	GetProxy func(ctx context.Context, url *url.URL) (string, error) //代理ip http://116.62.55.139:8888
 	Proxy    string                                                  //代理ip http://192.168.1.50:8888
 
+	ProxyProtocol    ProxyProtocolVersion                                         //连接目标地址后发送PROXY protocol 头,转发客户端真实地址,走上游代理时头部经代理隧道发给目标地址
+	GetProxyProtocol func(ctx context.Context, url *url.URL) ProxyProtocolVersion //根据目标地址返回PROXY protocol 版本,优先级高于ProxyProtocol
+
 	DialTimeout time.Duration                   //tls 握手超时时间
 	KeepAlive   time.Duration                   //保活时间
 	LocalAddr   *net.TCPAddr                    //本地网卡出口
@@ -45,12 +59,45 @@ type ClientOption struct {
 	AddrType    gtls.AddrType                   //host优先解析的类型
 	Dns         *net.UDPAddr
 
+	Limit *LimitOption //按客户端ip或用户限制连接数和带宽,超过连接限制时http 返回429,socks5 返回规则拒绝
+
+	TrafficStore    TrafficStore           //按认证用户统计流量,为空且设置了配额时使用内存统计
+	TrafficQuota    int64                  //每个用户的流量配额(字节),用完后拒绝新的会话,0 表示不限制
+	GetTrafficQuota func(usr string) int64 //根据用户返回流量配额,优先级高于TrafficQuota
+
+	Logger Logger //访问日志,内置NewJsonLogger,NewCombinedLogger
+
+	OnError func(*Session, ErrorStage, error) //连接出错回调,接受连接失败时会话为nil
+
+	Har *HarOption //记录解析的http 请求,保存为har 文件,https 需要中间人解密
+
+	Archive *ArchiveOption //录制请求到归档目录,或从归档回放响应,不连接目标地址
+
 	Debug     bool //是否打印debug
 	DisVerify bool //关闭验证
 	//发送请求和接收response 回调，返回error,则中断请求
 	RequestCallBack func(*http.Request, *http.Response) error
+	//http 请求中间件,可以修改请求和响应,https 需要中间人解密
+	Middlewares []Middleware
+	MapLocal    []MapLocal   //匹配的请求返回本地文件,不连接目标地址
+	MapRemote   []MapRemote  //匹配的请求改写到另一个地址
+	HeaderRules []HeaderRule //按规则修改请求头和响应头,https 需要中间人解密
+
+	Pool PoolOption //http 代理请求的服务端连接池
+
+	Via           string //转发http 请求时添加Via 头,值为代理名称
+	XForwardedFor bool   //转发http 请求时追加客户端ip 到X-Forwarded-For 头
+	Forwarded     bool   //转发http 请求时添加Forwarded 头
+	//流式读取请求和响应body,不缓存body,支持http1.1 和http2,https 需要中间人解密
+	BodyTap BodyTap
+	//解析sse 和ndjson 流式响应,收到事件时回调,可以修改事件,返回ErrEventDrop 丢弃事件,https 需要中间人解密
+	StreamCallBack func(*StreamEvent) error
+	//中间人解密时收到客户端tls 握手消息回调,可以获取ja3,ja4 指纹,返回error 则中断连接
+	ClientHelloCallBack func(*Session, *ClientHello) error
 	//websocket 传输回调，返回error,则中断请求
 	WsCallBack func(websocket.MessageType, []byte, WsType) error
+	//新的websocket 连接回调,返回的函数处理这个连接的消息,可以修改,丢弃,主动发送消息
+	WsHandler func(*WsConn) WsMessageHandler
 	//连接回调,返回error,则中断请求
 	HttpConnectCallBack func(*http.Request) error
 	//http,https 代理根据请求验证用户权限，返回error 则中断请求
@@ -58,9 +105,20 @@ type ClientOption struct {
 	//支持根据http,https代理的请求，动态生成ja3,h2指纹。注意这请求是客户端和代理协议协商的请求，不是客户端请求目标地址的请求
 	//返回空结构体，则不会设置指纹
 	CreateSpecWithHttp func(*http.Request) *requests.GospiderSpec
-	Spec               string
+	Spec               string //指纹,支持内置浏览器名称chrome,firefox,safari,edge,ios(只有tls 指纹)
 	TlsConfig          *tls.Config
 	UtlsConfig         *utls.Config
+	//中间人解密时使用客户端的tls 握手消息连接服务端,保持客户端的指纹,utls 无法复现时使用Spec
+	//需要解析http1.1(har,中间件等)时,客户端alpn 中的h2 会改为http/1.1,记录在Session.MirrorHttp1
+	MirrorSpec bool
+	//按域名选择指纹,可以设置多个指纹轮换,优先级高于Spec,低于CreateSpecWithHttp,http 和socks5 代理都生效
+	SpecRules []SpecRule
+	//中间人连接服务端时验证服务端证书,默认不验证
+	Verify VerifyOption
+	//连接服务端或https 上游代理时按地址使用的客户端证书
+	UpstreamCerts []UpstreamCert
+	//导出tls 会话密钥,NSS key log 格式(SSLKEYLOGFILE),用于wireshark 解密客户端和服务端两侧的流量
+	KeyLogWriter io.Writer
 }
 type WsType int
 
@@ -74,17 +132,35 @@ type Client struct {
 	debug               bool
 	disVerify           bool
 	requestCallBack     func(*http.Request, *http.Response) error
+	middlewares         []Middleware
+	bodyTap             BodyTap
+	streamCallBack      func(*StreamEvent) error
+	clientHelloCallBack func(*Session, *ClientHello) error
+	mapLocal            []MapLocal
+	mapRemote           []mapRemoteRule
+	headerRules         []headerRule
+	via                 string
+	xForwardedFor       bool
+	forwarded           bool
+	pool                *connPool
 	wsCallBack          func(websocket.MessageType, []byte, WsType) error
+	wsHandler           func(*WsConn) WsMessageHandler
 	httpConnectCallBack func(*http.Request) error
 	verifyAuthWithHttp  func(*http.Request) error
 	createSpecWithHttp  func(*http.Request) *requests.GospiderSpec
 
 	gospiderSpec *requests.GospiderSpec //gospider 指纹
+	mirrorSpec   bool                   //复用客户端的握手消息
+	specRules    []*specRule            //按域名选择指纹
 
 	err      error //错误
 	cert     tls.Certificate
-	dialer   *requests.Dialer //连接的Dialer
-	listener net.Listener     //Listener 服务
+	ca       func() (*Ca, error) //中间人根证书
+	certs    *certCache          //中间人证书缓存
+	mitm     *mitmFilter         //中间人解密范围
+	verify   VerifyOption        //服务端证书验证
+	dialer   *requests.Dialer    //连接的Dialer
+	listener net.Listener        //Listener 服务
 	basic    string
 	usr      string
 	pwd      string
@@ -96,11 +172,28 @@ type Client struct {
 
 	tlsConfig      *tls.Config
 	proxyTlsConfig *tls.Config
+	clientCert     *ClientCertOption //代理监听的客户端证书认证
 
-	utlsConfig *utls.Config
+	utlsConfig    *utls.Config
+	upstreamCerts []UpstreamCert //连接服务端或上游代理的客户端证书
 
 	getProxy func(ctx context.Context, url *url.URL) (string, error) //代理ip http://116.62.55.139:8888
 	proxy    *url.URL
+
+	proxyProtocol    ProxyProtocolVersion
+	getProxyProtocol func(ctx context.Context, url *url.URL) ProxyProtocolVersion
+
+	limiter *limiter
+
+	trafficStore    TrafficStore
+	trafficQuota    int64
+	getTrafficQuota func(usr string) int64
+
+	logger  Logger
+	onError func(*Session, ErrorStage, error)
+
+	har     *harRecorder
+	archive *archive
 }
 
 func NewClient(pre_ctx context.Context, option ClientOption) (*Client, error) {
@@ -122,6 +215,12 @@ func NewClient(pre_ctx context.Context, option ClientOption) (*Client, error) {
 			PreferSkipResumptionOnNilExtension: true,
 		}
 	}
+	if option.KeyLogWriter != nil {
+		option.TlsConfig = option.TlsConfig.Clone()
+		option.TlsConfig.KeyLogWriter = option.KeyLogWriter
+		option.UtlsConfig = option.UtlsConfig.Clone()
+		option.UtlsConfig.KeyLogWriter = option.KeyLogWriter
+	}
 	server := Client{
 		specClient:          ja3.NewClient(),
 		tlsConfig:           option.TlsConfig,
@@ -131,9 +230,27 @@ func NewClient(pre_ctx context.Context, option ClientOption) (*Client, error) {
 		disVerify:           option.DisVerify,
 		httpConnectCallBack: option.HttpConnectCallBack,
 		wsCallBack:          option.WsCallBack,
+		wsHandler:           option.WsHandler,
 		requestCallBack:     option.RequestCallBack,
+		middlewares:         option.Middlewares,
+		bodyTap:             option.BodyTap,
+		streamCallBack:      option.StreamCallBack,
+		clientHelloCallBack: option.ClientHelloCallBack,
+		mirrorSpec:          option.MirrorSpec,
+		mapLocal:            option.MapLocal,
+		via:                 option.Via,
+		xForwardedFor:       option.XForwardedFor,
+		forwarded:           option.Forwarded,
+		pool:                newConnPool(option.Pool),
 		verifyAuthWithHttp:  option.VerifyAuthWithHttp,
 		createSpecWithHttp:  option.CreateSpecWithHttp,
+		proxyProtocol:       option.ProxyProtocol,
+		getProxyProtocol:    option.GetProxyProtocol,
+		trafficStore:        option.TrafficStore,
+		trafficQuota:        option.TrafficQuota,
+		getTrafficQuota:     option.GetTrafficQuota,
+		logger:              option.Logger,
+		onError:             option.OnError,
 	}
 	if option.Addr == "" {
 		option.Addr = ":0"
@@ -142,17 +259,42 @@ func NewClient(pre_ctx context.Context, option ClientOption) (*Client, error) {
 
 	var spec *requests.GospiderSpec
 	if option.Spec != "" {
-		if spec, err = requests.ParseGospiderSpec(option.Spec); err != nil {
+		if spec, err = parseSpec(option.Spec); err != nil {
 			return nil, err
 		}
 	}
 	server.gospiderSpec = spec
+	if server.specRules, err = newSpecRules(option.SpecRules); err != nil {
+		return nil, err
+	}
 
 	if option.Proxy != "" {
 		if server.proxy, err = gtls.VerifyProxy(option.Proxy); err != nil {
 			return nil, err
 		}
 	}
+	if option.Limit != nil {
+		server.limiter = newLimiter(*option.Limit)
+	}
+	if option.Har != nil {
+		if server.har, err = newHarRecorder(*option.Har); err != nil {
+			return nil, err
+		}
+	}
+	if server.headerRules, err = newHeaderRules(option.HeaderRules); err != nil {
+		return nil, err
+	}
+	if server.mapRemote, err = newMapRemoteRules(option.MapRemote); err != nil {
+		return nil, err
+	}
+	if option.Archive != nil {
+		if server.archive, err = newArchive(*option.Archive); err != nil {
+			return nil, err
+		}
+	}
+	if server.trafficStore == nil && (server.trafficQuota > 0 || server.getTrafficQuota != nil) {
+		server.trafficStore = NewMemoryTrafficStore()
+	}
 	server.ctx, server.cnl = context.WithCancel(pre_ctx)
 	if option.Usr != "" && option.Pwd != "" {
 		server.basic = tools.Base64Encode(option.Usr + ":" + option.Pwd)
@@ -166,6 +308,27 @@ func NewClient(pre_ctx context.Context, option ClientOption) (*Client, error) {
 	}
 	//dialer
 	server.dialer = &requests.Dialer{}
+	//中间人根证书
+	var ca *Ca
+	if option.CaCrt != nil && option.CaKey != nil {
+		ca, err = LoadCa(option.CaCrt, option.CaKey)
+	} else if option.CaDir != "" {
+		ca, err = LoadOrCreateCa(option.CaDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ca != nil {
+		server.ca = func() (*Ca, error) { return ca, nil }
+	} else { //没有设置时用到再加载,不使用中间人时不创建根证书文件
+		server.ca = sync.OnceValues(defaultCa)
+	}
+	server.verify = option.Verify
+	server.upstreamCerts = option.UpstreamCerts
+	server.certs = newCertCache(server.ca, option.CertCache)
+	if server.mitm, err = newMitmFilter(option.Mitm); err != nil {
+		return nil, err
+	}
 	//证书
 
 	if option.CrtFile != nil && option.KeyFile != nil {
@@ -183,11 +346,14 @@ func NewClient(pre_ctx context.Context, option ClientOption) (*Client, error) {
 			}
 			server.proxyTlsConfig.NextProtos = []string{"http/1.1"}
 		} else {
-			server.proxyTlsConfig = gtls.GetCertConfigForClient(&tls.Config{
-				NextProtos: []string{"http/1.1"},
-			})
+			server.proxyTlsConfig = server.certs.serverConfig()
 		}
 	}
+	server.proxyTlsConfig.KeyLogWriter = option.KeyLogWriter
+	if option.ClientCert != nil {
+		server.clientCert = option.ClientCert
+		server.clientCert.serverConfig(server.proxyTlsConfig)
+	}
 	//构造listen
 	if server.listener, err = net.Listen("tcp", option.Addr); err != nil {
 		return nil, err
@@ -218,6 +384,131 @@ func (obj *Client) GetProxy(ctx context.Context, href *url.URL) (*url.URL, error
 	return nil, nil
 }
 
+func (obj *Client) GetProxyProtocol(ctx context.Context, href *url.URL) ProxyProtocolVersion {
+	if obj.getProxyProtocol != nil {
+		return obj.getProxyProtocol(ctx, href)
+	}
+	return obj.proxyProtocol
+}
+
+// 连接目标地址,有代理则走代理,需要时发送PROXY protocol 头
+func (obj *Client) dialServer(ctx context.Context, client *ProxyConn, href *url.URL, proxyUrl *url.URL, remoteAddress requests.Address) (net.Conn, error) {
+	var proxyServer net.Conn
+	var err error
+	if proxyUrl != nil {
+		client.option.session.Upstream = proxyUrl.Redacted()
+		proxyAddress, err := requests.GetAddressWithUrl(proxyUrl)
+		if err != nil {
+			return nil, withStage(StageDial, err)
+		}
+		proxyTlsConfig := obj.TlsConfig()
+		if cert := obj.upstreamCert(proxyUrl.Host); cert != nil { //https 上游代理的客户端证书
+			proxyTlsConfig.Certificates = []tls.Certificate{*cert}
+		}
+		_, proxyServer, err = obj.dialer.DialProxyContext(requests.NewResponse(ctx, requests.RequestOption{}), "tcp", proxyTlsConfig, proxyAddress, remoteAddress)
+		if err != nil {
+			return nil, withStage(StageDial, err)
+		}
+	} else if proxyServer, err = obj.dialer.DialContext(requests.NewResponse(ctx, requests.RequestOption{}), "tcp", remoteAddress); err != nil {
+		return nil, withStage(StageDial, err)
+	}
+	if err = writeProxyProtocol(proxyServer, obj.GetProxyProtocol(ctx, href), client.RemoteAddr(), client.LocalAddr()); err != nil {
+		proxyServer.Close()
+		return nil, withStage(StageDial, err)
+	}
+	return proxyServer, nil
+}
+
+// 按请求地址获取服务端连接,优先复用连接池的空闲连接,新连接https 完成tls 握手
+func (obj *Client) dialRequest(ctx context.Context, client *ProxyConn, href *url.URL) (*ProxyConn, error) {
+	proxyUrl, err := obj.GetProxy(ctx, href)
+	if err != nil {
+		return nil, withStage(StageDial, err)
+	}
+	var key string
+	if obj.GetProxyProtocol(ctx, href) == ProxyProtocolNone { //PROXY protocol 头包含客户端地址,不能复用
+		key = poolKey(href, proxyUrl, client.option.gospiderSpec)
+	}
+	if server := obj.pool.get(key); server != nil {
+		if proxyUrl != nil {
+			client.option.session.Upstream = proxyUrl.Redacted()
+		}
+		return server, nil
+	}
+	remoteAddress, err := requests.GetAddressWithUrl(href)
+	if err != nil {
+		return nil, withStage(StageDial, err)
+	}
+	remoteAddress.Scheme = href.Scheme
+	conn, err := obj.dialServer(ctx, client, href, proxyUrl, remoteAddress)
+	if err != nil {
+		return nil, err
+	}
+	if href.Scheme == "https" {
+		tlsConn, _, err := obj.tlsServer(ctx, conn, href.Host, []string{"http/1.1"}, client.option)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	server := newProxyCon(conn, bufio.NewReader(conn), *client.option, false)
+	server.target = href.Host
+	server.poolKey = key
+	return server, nil
+}
+
+// 占用限流的连接数,并给客户端连接设置带宽限制,返回释放函数
+func (obj *Client) acquireLimit(client *ProxyConn) (func(), error) {
+	if obj.limiter == nil {
+		return func() {}, nil
+	}
+	var key string
+	if obj.limiter.option.ByUser && client.option.session.User != "" {
+		key = "usr:" + client.option.session.User
+	} else {
+		host, _, err := net.SplitHostPort(client.RemoteAddr().String())
+		if err != nil {
+			return nil, err
+		}
+		key = "ip:" + host
+	}
+	entry, err := obj.limiter.acquire(key)
+	if err != nil {
+		return nil, err
+	}
+	client.option.session.limit = entry
+	return func() { obj.limiter.release(entry) }, nil
+}
+
+// 用户的流量配额,0 表示不限制
+func (obj *Client) GetTrafficQuota(usr string) int64 {
+	if obj.getTrafficQuota != nil {
+		return obj.getTrafficQuota(usr)
+	}
+	return obj.trafficQuota
+}
+
+// 校验用户流量配额,并给客户端连接设置流量统计
+func (obj *Client) verifyTraffic(client *ProxyConn) error {
+	if obj.trafficStore == nil || client.option.session.User == "" {
+		return nil
+	}
+	if quota := obj.GetTrafficQuota(client.option.session.User); quota > 0 {
+		used, err := obj.trafficStore.Get(client.option.session.User)
+		if err != nil {
+			return err
+		}
+		if used >= quota {
+			return errTrafficQuota
+		}
+	}
+	session := client.option.session
+	session.traffic = &trafficCounter{store: obj.trafficStore, usr: session.User}
+	session.traffic.add(int(session.UpBytes() + session.DownBytes())) //认证前已经读取的请求
+	return nil
+}
+
 // 代理监听的端口
 func (obj *Client) Addr() string {
 	return net.JoinHostPort(obj.host, strconv.Itoa(obj.port))
@@ -234,6 +525,9 @@ func (obj *Client) Run() error {
 			client, err := obj.listener.Accept() //接受数据
 			if err != nil {
 				obj.err = err
+				if obj.onError != nil && !errors.Is(err, net.ErrClosed) {
+					obj.onError(nil, StageAccept, withStage(StageAccept, err))
+				}
 				return err
 			}
 			go obj.mainHandle(obj.ctx, client)
@@ -243,6 +537,10 @@ func (obj *Client) Run() error {
 func (obj *Client) Close() {
 	obj.listener.Close()
 	obj.cnl()
+	obj.pool.Close()
+	if obj.har != nil {
+		obj.har.Close()
+	}
 }
 func (obj *Client) Done() <-chan struct{} {
 	return obj.ctx.Done()
@@ -261,11 +559,11 @@ func (obj *Client) whiteVerify(client net.Conn) bool {
 
 // 返回:请求所有内容,第一行的内容被" "分割的数组,第一行的内容,error
 func (obj *Client) verifyPwd(client net.Conn, clientReq *http.Request) error {
-	if obj.basic == "" {
+	if obj.basic == "" && obj.clientCert == nil {
 		return nil
 	}
 	for kk, vvs := range clientReq.Header {
-		if strings.Contains(kk, "Authorization") {
+		if obj.basic != "" && strings.Contains(kk, "Authorization") {
 			for _, vv := range vvs {
 				if strings.Contains(vv, obj.basic) {
 					return nil
@@ -283,8 +581,32 @@ func (obj *Client) verifyPwd(client net.Conn, clientReq *http.Request) error {
 	return errors.New("auth verify fail")
 }
 
+// Proxy-Authorization 与设置的账号密码一致时返回用户名
+func (obj *Client) basicUser(clientReq *http.Request) string {
+	if obj.basic != "" && strings.TrimSpace(clientReq.Header.Get("Proxy-Authorization")) == "Basic "+obj.basic {
+		return obj.usr
+	}
+	return ""
+}
+
+// 获取http 代理认证的用户名
+func getProxyAuthUser(clientReq *http.Request) string {
+	auth, ok := strings.CutPrefix(clientReq.Header.Get("Proxy-Authorization"), "Basic ")
+	if !ok {
+		return ""
+	}
+	userInfo, err := tools.Base64Decode(strings.TrimSpace(auth))
+	if err != nil {
+		return ""
+	}
+	usr, _, _ := strings.Cut(string(userInfo), ":")
+	return usr
+}
+
 func (obj *Client) mainHandle(ctx context.Context, client net.Conn) (err error) {
-	defer recover()
+	if client == nil {
+		return errors.New("client is nil")
+	}
 	defer client.Close()
 	if obj.debug {
 		defer func() {
@@ -294,23 +616,59 @@ func (obj *Client) mainHandle(ctx context.Context, client net.Conn) (err error)
 			}
 		}()
 	}
-	if client == nil {
-		return errors.New("client is nil")
+	session := newSession(client)
+	if obj.har != nil {
+		defer obj.har.closeSession(session)
 	}
-	if obj.basic == "" && !obj.whiteVerify(client) {
-		return errors.New("auth verify false")
+	if obj.onError != nil {
+		defer func() {
+			if err != nil {
+				obj.onError(session, GetErrorStage(err), err)
+			}
+		}()
+	}
+	if obj.logger != nil {
+		defer func() {
+			if session.logged.Load() { //已经按请求记录
+				return
+			}
+			accessLog := session.accessLog()
+			if err != nil {
+				accessLog.Error = err.Error()
+			}
+			obj.logger.Log(accessLog)
+		}()
 	}
-	clientReader := bufio.NewReader(client)
+	defer func() { //最先执行,后面的defer 可以拿到panic 错误
+		if r := recover(); r != nil {
+			err = panicError(r)
+		}
+	}()
+	if obj.basic == "" && obj.clientCert == nil && !obj.whiteVerify(client) {
+		return withStage(StageAuth, errors.New("auth verify false"))
+	}
+	ctx, cnl := context.WithCancel(ctx)
+	defer cnl()
+	client = &sessionConn{Conn: client, session: session, ctx: ctx, cnl: cnl}
+	clientReader := bufio.NewReaderSize(client, clientReaderSize)
 	firstCons, err := clientReader.Peek(1)
 	if err != nil {
-		return err
+		return withStage(StageSniff, err)
+	}
+	if obj.basic == "" && obj.clientCert != nil && firstCons[0] != 22 && !obj.whiteVerify(client) { //开启客户端证书认证时,非tls 连接只允许白名单
+		return withStage(StageAuth, errors.New("auth verify false"))
 	}
+	ctx = context.WithValue(ctx, sessionKey{}, session)
+	option := ProxyOption{session: session}
 	switch firstCons[0] {
 	case 5: //socks5 代理
-		return obj.sockes5Handle(ctx, newProxyCon(client, clientReader, ProxyOption{}, true))
+		session.Protocol = "socks5"
+		return obj.sockes5Handle(ctx, newProxyCon(client, clientReader, option, true))
 	case 22: //https 代理
-		return obj.httpsHandle(ctx, newProxyCon(client, clientReader, ProxyOption{}, true))
+		session.Protocol = "https"
+		return obj.httpsHandle(ctx, newProxyCon(client, clientReader, option, true))
 	default: //http 代理
-		return obj.httpHandle(ctx, newProxyCon(client, clientReader, ProxyOption{}, true))
+		session.Protocol = "http"
+		return obj.httpHandle(ctx, newProxyCon(client, clientReader, option, true))
 	}
 }
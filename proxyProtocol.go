@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// PROXY protocol 版本
+type ProxyProtocolVersion int
+
+const (
+	ProxyProtocolNone ProxyProtocolVersion = 0
+	ProxyProtocolV1   ProxyProtocolVersion = 1
+	ProxyProtocolV2   ProxyProtocolVersion = 2
+)
+
+var proxyProtocolV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// 获取tcp 地址,非tcp 地址返回nil
+func proxyProtocolAddr(addr net.Addr) *net.TCPAddr {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || tcpAddr.IP == nil {
+		return nil
+	}
+	return tcpAddr
+}
+
+// 写入PROXY protocol 头,src 为客户端地址,dst 为客户端连接代理的地址
+func writeProxyProtocol(w io.Writer, version ProxyProtocolVersion, src net.Addr, dst net.Addr) error {
+	var header []byte
+	switch version {
+	case ProxyProtocolNone:
+		return nil
+	case ProxyProtocolV1:
+		header = proxyProtocolV1Header(proxyProtocolAddr(src), proxyProtocolAddr(dst))
+	case ProxyProtocolV2:
+		header = proxyProtocolV2Header(proxyProtocolAddr(src), proxyProtocolAddr(dst))
+	default:
+		return fmt.Errorf("not supported proxy protocol version:%v", version)
+	}
+	_, err := w.Write(header)
+	return err
+}
+func proxyProtocolV1Header(src *net.TCPAddr, dst *net.TCPAddr) []byte {
+	if src == nil || dst == nil {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	srcIp, dstIp := src.IP.To4(), dst.IP.To4()
+	if srcIp != nil && dstIp != nil {
+		return fmt.Appendf(nil, "PROXY TCP4 %s %s %d %d\r\n", srcIp, dstIp, src.Port, dst.Port)
+	}
+	return fmt.Appendf(nil, "PROXY TCP6 %s %s %d %d\r\n", src.IP.To16(), dst.IP.To16(), src.Port, dst.Port)
+}
+func proxyProtocolV2Header(src *net.TCPAddr, dst *net.TCPAddr) []byte {
+	header := bytes.NewBuffer(make([]byte, 0, 52))
+	header.Write(proxyProtocolV2Sig)
+	if src == nil || dst == nil { //LOCAL 命令,不携带地址
+		header.Write([]byte{0x20, 0x00, 0x00, 0x00})
+		return header.Bytes()
+	}
+	header.WriteByte(0x21) //version 2,PROXY 命令
+	var srcIp, dstIp net.IP
+	if srcIp, dstIp = src.IP.To4(), dst.IP.To4(); srcIp != nil && dstIp != nil {
+		header.WriteByte(0x11) //TCP over IPv4
+	} else {
+		srcIp, dstIp = src.IP.To16(), dst.IP.To16()
+		header.WriteByte(0x21) //TCP over IPv6
+	}
+	binary.Write(header, binary.BigEndian, uint16(len(srcIp)*2+4))
+	header.Write(srcIp)
+	header.Write(dstIp)
+	binary.Write(header, binary.BigEndian, uint16(src.Port))
+	binary.Write(header, binary.BigEndian, uint16(dst.Port))
+	return header.Bytes()
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 客户端会话,一个客户端连接对应一个会话
+type Session struct {
+	ClientAddr net.Addr  //客户端地址
+	Protocol   string    //客户端使用的代理协议:http,https,socks5
+	User       string    //认证的用户名
+	Method     string    //建立会话的请求方法
+	Url        string    //建立会话的请求地址
+	Host       string    //目标地址
+	Upstream   string    //上游代理
+	StartTime  time.Time //会话开始时间
+
+	ClientHello *ClientHello //中间人解密时客户端的tls 握手消息
+	MirrorHttp1 bool         //MirrorSpec 需要解析http1.1 时,发给服务端的alpn 由h2 改为http/1.1,指纹和客户端不一致
+
+	upBytes   atomic.Int64
+	downBytes atomic.Int64
+	logged    atomic.Bool
+
+	limit   *limitEntry     //带宽限制
+	traffic *trafficCounter //流量统计
+
+	harLock    sync.Mutex
+	harEntries []*HarEntry
+}
+
+func newSession(client net.Conn) *Session {
+	return &Session{ClientAddr: client.RemoteAddr(), StartTime: time.Now()}
+}
+
+// 客户端发送的字节数
+func (obj *Session) UpBytes() int64 {
+	return obj.upBytes.Load()
+}
+
+// 发送给客户端的字节数
+func (obj *Session) DownBytes() int64 {
+	return obj.downBytes.Load()
+}
+
+// 统计会话流量并限制带宽的客户端连接,所有读写都经过这里
+type sessionConn struct {
+	net.Conn
+	session *Session
+	ctx     context.Context
+	cnl     context.CancelFunc
+}
+
+func (obj *sessionConn) Read(b []byte) (int, error) {
+	n, err := obj.Conn.Read(b)
+	if waitErr := obj.session.up(obj.ctx, n); err == nil {
+		err = waitErr
+	}
+	return n, err
+}
+func (obj *sessionConn) Write(b []byte) (int, error) {
+	if err := obj.session.waitDown(obj.ctx, len(b)); err != nil {
+		return 0, err
+	}
+	n, err := obj.Conn.Write(b)
+	obj.session.down(n)
+	return n, err
+}
+
+// 统计客户端发送的字节并限速,tcp 和udp 都经过这里
+func (obj *Session) up(ctx context.Context, n int) error {
+	obj.upBytes.Add(int64(n))
+	obj.traffic.add(n)
+	if obj.limit != nil {
+		return obj.limit.up.wait(ctx, n)
+	}
+	return nil
+}
+
+// 发送给客户端前限速
+func (obj *Session) waitDown(ctx context.Context, n int) error {
+	if obj.limit != nil {
+		return obj.limit.down.wait(ctx, n)
+	}
+	return nil
+}
+
+// 统计发送给客户端的字节
+func (obj *Session) down(n int) {
+	obj.downBytes.Add(int64(n))
+	obj.traffic.add(n)
+}
+
+// 关闭连接时结束等待中的限速
+func (obj *sessionConn) Close() error {
+	obj.cnl()
+	return obj.Conn.Close()
+}
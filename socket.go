@@ -88,8 +88,10 @@ func (s *Client) udpMain(ctx context.Context, client *ProxyConn) error {
 			if addr.String() != targetAddr.String() {
 				continue
 			}
-			_, err = udpConn.WriteTo(reader.Bytes(), targetAddr)
-			if err != nil {
+			if err = client.option.session.up(ctx, reader.Len()); err != nil {
+				return err
+			}
+			if _, err = udpConn.WriteTo(reader.Bytes(), targetAddr); err != nil {
 				return err
 			}
 		} else if targetAddr != nil && targetAddr.String() == gotAddr.String() {
@@ -101,12 +103,15 @@ func (s *Client) udpMain(ctx context.Context, client *ProxyConn) error {
 				}
 				replyPrefix = b.Bytes()
 			}
+			if err = client.option.session.waitDown(ctx, n); err != nil {
+				return err
+			}
 			copy(buf[len(replyPrefix):len(replyPrefix)+n], buf[:n])
 			copy(buf[:len(replyPrefix)], replyPrefix)
-			_, err = udpConn.WriteTo(buf[:len(replyPrefix)+n], sourceAddr)
-			if err != nil {
+			if _, err = udpConn.WriteTo(buf[:len(replyPrefix)+n], sourceAddr); err != nil {
 				return err
 			}
+			client.option.session.down(n)
 		}
 	}
 }
@@ -122,54 +127,49 @@ func (obj *Client) tcpMain(ctx context.Context, client *ProxyConn) error {
 	var remoteAddress requests.Address
 	remoteAddress, err = requests.ReadUdpAddr(client.reader)
 	if err != nil {
-		return err
+		return withStage(StageSniff, err)
 	}
 	remoteAddress.Scheme = client.option.schema
 	remoteAddress.Host = remoteAddress.IP.String()
 	if strings.HasPrefix(remoteAddress.String(), "127.0.0.1") || strings.HasPrefix(remoteAddress.String(), "localhost") {
 		if remoteAddress.Port == obj.port {
-			return errors.New("loop addr error")
+			return withStage(StageDial, errors.New("loop addr error"))
 		}
 	}
+	client.option.session.Host = remoteAddress.String()
 	pu, _ := url.Parse(remoteAddress.String())
 	//获取代理
 	proxyUrl, err := obj.GetProxy(ctx, pu)
 	if err != nil {
-		return err
+		return withStage(StageDial, err)
 	}
 	//获取schema
 	httpsBytes, err := client.reader.Peek(1)
 	if err != nil {
-		return err
+		return withStage(StageSniff, err)
 	}
 	client.option.schema = "http"
 	if httpsBytes[0] == 22 {
 		client.option.schema = "https"
 		client.option.method = http.MethodConnect
 	}
-	netword := "tcp"
-	var proxyServer net.Conn
-	if proxyUrl != nil {
-		var proxyAddress requests.Address
-		proxyAddress, err = requests.GetAddressWithUrl(proxyUrl)
-		if err != nil {
-			return err
-		}
-		_, proxyServer, err = obj.dialer.DialProxyContext(requests.NewResponse(ctx, requests.RequestOption{}), netword, obj.TlsConfig(), proxyAddress, remoteAddress)
-	} else {
-		proxyServer, err = obj.dialer.DialContext(requests.NewResponse(ctx, requests.RequestOption{}), netword, remoteAddress)
-	}
+	proxyServer, err := obj.dialServer(ctx, client, pu, proxyUrl, remoteAddress)
 	if err != nil {
 		return err
 	}
 	server := newProxyCon(proxyServer, bufio.NewReader(proxyServer), *client.option, false)
+	server.target = net.JoinHostPort(remoteAddress.Host, strconv.Itoa(remoteAddress.Port))
 	client.option.port = strconv.Itoa(remoteAddress.Port)
 	client.option.host = remoteAddress.Host
 	server.option.port = strconv.Itoa(remoteAddress.Port)
 	server.option.host = remoteAddress.Host
 	defer server.Close()
 	if client.option.schema == "https" {
-		client.option.gospiderSpec = obj.gospiderSpec
+		var serverName string
+		if hello, err := peekClientHello(client.reader); err == nil {
+			serverName = hello.ServerName
+		}
+		client.option.gospiderSpec = obj.selectSpec(client.option.session, serverName, remoteAddress.Host)
 	}
 	return obj.copyMain(ctx, client, server)
 
@@ -178,24 +178,42 @@ func (obj *Client) sockes5Handle(ctx context.Context, client *ProxyConn) error {
 	defer client.Close()
 	var err error
 	if err = obj.verifySocket(client); err != nil {
-		return err
+		return withStage(StageAuth, err)
 	}
 	//获取serverAddr
 	cmd, err := obj.getCmd(client)
 	if err != nil {
-		return err
+		return withStage(StageSniff, err)
+	}
+	if err = obj.verifyTraffic(client); err != nil {
+		writeSocksRefuse(client)
+		return withStage(StageAuth, err)
 	}
+	release, err := obj.acquireLimit(client)
+	if err != nil {
+		writeSocksRefuse(client)
+		return withStage(StageAuth, err)
+	}
+	defer release()
 	switch cmd {
 	case 1:
 		return obj.tcpMain(ctx, client)
 	case 3:
 		return obj.udpMain(ctx, client)
 	default:
-		return fmt.Errorf("not supported cmd:%v", cmd)
+		return withStage(StageSniff, fmt.Errorf("not supported cmd:%v", cmd))
 	}
 
 }
 
+// 返回规则不允许连接
+func writeSocksRefuse(client *ProxyConn) error {
+	if _, err := client.Write([]byte{0x05, 0x02, 0x00}); err != nil {
+		return err
+	}
+	return requests.WriteUdpAddr(client, requests.Address{IP: net.IPv4(0, 0, 0, 0), Port: 0})
+}
+
 func (obj *Client) getCmd(client *ProxyConn) (byte, error) {
 	buf := make([]byte, 3)
 	_, err := io.ReadFull(client.reader, buf) //读取版本号，CMD，RSV ，ATYP ，ADDR ，PORT
@@ -256,6 +274,7 @@ func (obj *Client) verifySocket(client *ProxyConn) error {
 			client.Write([]byte{okVar, 0xff}) //用户名密码错误
 			return errors.New("用户名密码错误")
 		}
+		client.option.session.User = obj.usr
 		_, err = client.Write([]byte{okVar, 0}) //协商成功
 		return err
 	}
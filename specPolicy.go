@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gospider007/ja3"
+	"github.com/gospider007/requests"
+	utls "github.com/refraction-networking/utls"
+)
+
+// 多个指纹的选择策略
+type SpecStrategy int
+
+const (
+	SpecFixed      SpecStrategy = iota //使用第一个指纹
+	SpecRandom                         //每个会话随机选择
+	SpecRoundRobin                     //每个会话轮流使用
+	SpecSticky                         //同一个用户固定使用一个指纹,没有认证用户时按客户端ip
+)
+
+// 按域名选择指纹
+type SpecRule struct {
+	Host     string   //匹配的域名,支持通配符*.example.com,为空时匹配所有
+	Specs    []string //指纹,内置浏览器名称chrome,firefox,safari,edge,ios(只有tls 指纹) 或gospider 指纹
+	Strategy SpecStrategy
+}
+
+type specRule struct {
+	host     string
+	specs    []*requests.GospiderSpec
+	strategy SpecStrategy
+	next     atomic.Uint64
+}
+
+// 内置的浏览器tls 指纹,不包含h2 指纹
+var builtinSpecIds = map[string]utls.ClientHelloID{
+	"chrome":  utls.HelloChrome_Auto,
+	"firefox": utls.HelloFirefox_Auto,
+	"safari":  utls.HelloSafari_Auto,
+	"edge":    utls.HelloEdge_Auto,
+	"ios":     utls.HelloIOS_Auto,
+}
+var builtinSpecs sync.Map
+
+// 内置浏览器tls 指纹的名称
+func BuiltinTlsSpecNames() []string {
+	names := make([]string, 0, len(builtinSpecIds))
+	for name := range builtinSpecIds {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// 内置的浏览器tls 指纹,由utls 生成握手消息,只设置TLSSpec,需要h2 指纹时使用gospider 指纹
+func BuiltinTlsSpec(name string) (*requests.GospiderSpec, error) {
+	if spec, ok := builtinSpecs.Load(name); ok {
+		return spec.(*requests.GospiderSpec), nil
+	}
+	helloId, ok := builtinSpecIds[name]
+	if !ok {
+		return nil, errors.New("unknown builtin spec: " + name)
+	}
+	conn, _ := net.Pipe()
+	defer conn.Close()
+	uconn := utls.UClient(conn, &utls.Config{ServerName: "example.com"}, helloId)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		return nil, err
+	}
+	for i, ext := range uconn.Extensions {
+		if _, ok := ext.(*utls.SNIExtension); ok { //只保留sni 扩展,不保存域名,连接时使用目标域名
+			uconn.Extensions[i] = &utls.GenericExtension{Id: 0}
+		}
+	}
+	if err := uconn.MarshalClientHello(); err != nil {
+		return nil, err
+	}
+	raw := uconn.HandshakeState.Hello.Raw
+	record := make([]byte, 5, 5+len(raw))
+	record[0], record[1], record[2] = 22, 3, 1
+	binary.BigEndian.PutUint16(record[3:], uint16(len(raw)))
+	tlsSpec, err := ja3.ParseSpec(append(record, raw...))
+	if err != nil {
+		return nil, err
+	}
+	spec, _ := builtinSpecs.LoadOrStore(name, &requests.GospiderSpec{TLSSpec: tlsSpec})
+	return spec.(*requests.GospiderSpec), nil
+}
+
+// 解析指纹,支持内置浏览器名称
+func parseSpec(value string) (*requests.GospiderSpec, error) {
+	if _, ok := builtinSpecIds[value]; ok {
+		return BuiltinTlsSpec(value)
+	}
+	return requests.ParseGospiderSpec(value)
+}
+
+func newSpecRules(rules []SpecRule) ([]*specRule, error) {
+	specRules := make([]*specRule, len(rules))
+	for i, rule := range rules {
+		if len(rule.Specs) == 0 {
+			return nil, errors.New("spec rule without specs")
+		}
+		specRules[i] = &specRule{host: strings.ToLower(rule.Host), strategy: rule.Strategy}
+		for _, value := range rule.Specs {
+			spec, err := parseSpec(value)
+			if err != nil {
+				return nil, err
+			}
+			specRules[i].specs = append(specRules[i].specs, spec)
+		}
+	}
+	return specRules, nil
+}
+
+func (obj *specRule) match(hosts []string) bool {
+	for _, host := range hosts {
+		if host = mitmHost(host); host != "" && globMatch(obj.host, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (obj *specRule) choose(session *Session) *requests.GospiderSpec {
+	switch obj.strategy {
+	case SpecRandom:
+		return obj.specs[rand.IntN(len(obj.specs))]
+	case SpecRoundRobin:
+		return obj.specs[(obj.next.Add(1)-1)%uint64(len(obj.specs))]
+	case SpecSticky:
+		key := session.User
+		if key == "" && session.ClientAddr != nil {
+			key = session.ClientAddr.String()
+			if host, _, err := net.SplitHostPort(key); err == nil {
+				key = host
+			}
+		}
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return obj.specs[h.Sum32()%uint32(len(obj.specs))]
+	default:
+		return obj.specs[0]
+	}
+}
+
+// 按目标地址选择会话使用的指纹,没有匹配的规则时使用Spec
+func (obj *Client) selectSpec(session *Session, hosts ...string) *requests.GospiderSpec {
+	for _, rule := range obj.specRules {
+		if rule.match(hosts) {
+			return rule.choose(session)
+		}
+	}
+	return obj.gospiderSpec
+}
@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// 流式事件回调返回ErrEventDrop 时丢弃这个事件
+var ErrEventDrop = errors.New("stream event dropped")
+
+var errStreamTooLong = errors.New("stream event too long")
+
+const streamMaxEvent = 1 << 20 //单个事件的最大长度,超过后不再解析,剩余内容原样转发
+
+// 流式响应类型
+type StreamType string
+
+const (
+	StreamSse    StreamType = "sse"    //text/event-stream
+	StreamNdjson StreamType = "ndjson" //每行一个json
+)
+
+// 流式响应的一个事件,修改字段后按修改后的内容转发
+type StreamEvent struct {
+	Session *Session
+	Url     string
+	Type    StreamType
+	Index   int    //事件序号,从0 开始
+	Event   string //sse 事件类型
+	Id      string //sse 事件id
+	Retry   string //sse 重连时间
+	Data    string //sse 多行data 用\n 连接,ndjson 为一行内容
+	raw     []byte
+}
+
+// 事件的原始内容
+func (obj *StreamEvent) Raw() []byte {
+	return obj.raw
+}
+
+// 根据Content-Type 判断流式响应类型
+func getStreamType(rsp *http.Response) StreamType {
+	mediaType, _, _ := mime.ParseMediaType(rsp.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/event-stream":
+		return StreamSse
+	case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines", "application/stream+json":
+		return StreamNdjson
+	}
+	return ""
+}
+
+// 解析流式响应,逐个事件回调后转发
+type streamBody struct {
+	body     io.ReadCloser
+	reader   *bufio.Reader
+	callBack func(*StreamEvent) error
+	event    StreamEvent
+	buf      bytes.Buffer //待转发的内容
+	err      error
+	raw      bool //不再解析,直接转发
+}
+
+func (obj *streamBody) Read(p []byte) (int, error) {
+	for obj.buf.Len() == 0 && obj.err == nil && !obj.raw {
+		obj.err = obj.next()
+	}
+	if obj.buf.Len() > 0 {
+		return obj.buf.Read(p)
+	}
+	if obj.raw && obj.err == nil {
+		return obj.reader.Read(p)
+	}
+	return 0, obj.err
+}
+func (obj *streamBody) Close() error {
+	return obj.body.Close()
+}
+
+// 读取一行追加到raw,raw 超过streamMaxEvent 时返回errStreamTooLong
+func (obj *streamBody) readLine(raw []byte) ([]byte, []byte, error) {
+	start := len(raw)
+	for {
+		chunk, err := obj.reader.ReadSlice('\n')
+		raw = append(raw, chunk...)
+		if len(raw) > streamMaxEvent {
+			return raw, nil, errStreamTooLong
+		}
+		if err != bufio.ErrBufferFull {
+			return raw, raw[start:], err
+		}
+	}
+}
+
+// 读取下一个事件,写入待转发的内容
+func (obj *streamBody) next() error {
+	var raw []byte
+	var fields []string
+	for {
+		var line []byte
+		var err error
+		raw, line, err = obj.readLine(raw)
+		if err != nil {
+			obj.buf.Write(raw) //不完整的事件原样转发
+			if err == errStreamTooLong {
+				obj.raw = true
+				return nil
+			}
+			return err
+		}
+		content := strings.TrimRight(string(line), "\r\n")
+		if obj.event.Type == StreamNdjson {
+			if content == "" {
+				obj.buf.Write(raw)
+				return nil
+			}
+			fields = append(fields, content)
+			break
+		}
+		if content == "" { //sse 空行结束事件
+			if len(fields) == 0 { //空行或只有注释,不回调
+				obj.buf.Write(raw)
+				return nil
+			}
+			break
+		}
+		if strings.HasPrefix(content, ":") { //sse 注释
+			continue
+		}
+		fields = append(fields, content)
+	}
+	event := obj.event
+	event.raw = raw
+	event.Event, event.Id, event.Retry, event.Data = "", "", "", ""
+	if event.Type == StreamNdjson {
+		event.Data = fields[0]
+	} else {
+		var data []string
+		for _, field := range fields {
+			name, value, _ := strings.Cut(field, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch name {
+			case "event":
+				event.Event = value
+			case "id":
+				event.Id = value
+			case "retry":
+				event.Retry = value
+			case "data":
+				data = append(data, value)
+			}
+		}
+		event.Data = strings.Join(data, "\n")
+	}
+	origin := event
+	obj.event.Index++
+	if err := obj.callBack(&event); err != nil {
+		if errors.Is(err, ErrEventDrop) {
+			return nil
+		}
+		return err
+	}
+	if event.Event == origin.Event && event.Id == origin.Id && event.Retry == origin.Retry && event.Data == origin.Data {
+		obj.buf.Write(raw)
+		return nil
+	}
+	if event.Type == StreamNdjson {
+		obj.buf.WriteString(event.Data + "\n")
+		return nil
+	}
+	if event.Event != "" {
+		obj.buf.WriteString("event: " + event.Event + "\n")
+	}
+	if event.Id != "" {
+		obj.buf.WriteString("id: " + event.Id + "\n")
+	}
+	if event.Retry != "" {
+		obj.buf.WriteString("retry: " + event.Retry + "\n")
+	}
+	for _, line := range strings.Split(event.Data, "\n") {
+		obj.buf.WriteString("data: " + line + "\n")
+	}
+	obj.buf.WriteString("\n")
+	return nil
+}
+
+// sse 和ndjson 响应改为逐个事件转发,内容长度会变化,改为chunked 编码
+func (obj *Client) streamResponse(client *ProxyConn, req *http.Request, rsp *http.Response) {
+	if obj.streamCallBack == nil || rsp.Body == nil || rsp.Body == http.NoBody {
+		return
+	}
+	streamType := getStreamType(rsp)
+	if streamType == "" || len(contentEncodings(rsp.Header)) > 0 { //压缩的流不解析
+		return
+	}
+	rsp.Body = &streamBody{
+		body:     rsp.Body,
+		reader:   bufio.NewReader(rsp.Body),
+		callBack: obj.streamCallBack,
+		event: StreamEvent{
+			Session: client.option.session,
+			Url:     req.URL.String(),
+			Type:    streamType,
+		},
+	}
+	rsp.Header.Del("Content-Length")
+	rsp.ContentLength = -1
+	if rsp.ProtoAtLeast(1, 1) {
+		rsp.TransferEncoding = []string{"chunked"}
+	} else {
+		rsp.Close = true
+	}
+}
@@ -0,0 +1,309 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// body 方向
+type BodyDirection int
+
+const (
+	BodyRequest  BodyDirection = 1 //客户端发送的请求body
+	BodyResponse BodyDirection = 2 //服务端返回的响应body
+)
+
+// body 所属请求的信息
+type BodyMeta struct {
+	Session    *Session
+	StreamId   uint32 //http2 流id,http1.1 为0
+	Method     string
+	Url        string
+	StatusCode int         //响应的状态码,请求body 为0
+	Header     http.Header //body 所属的请求头或响应头
+	Offset     int64       //chunk 在body 中的偏移
+	Done       bool        //body 结束,chunk 为空
+}
+
+// 流式读取body 的回调,同步调用,回调返回前不会继续转发,chunk 在回调返回后失效
+type BodyTap func(meta *BodyMeta, chunk []byte, direction BodyDirection)
+
+// 转发body 时调用回调
+type tapBody struct {
+	io.ReadCloser
+	tap       BodyTap
+	meta      BodyMeta
+	direction BodyDirection
+}
+
+func (obj *tapBody) Read(p []byte) (int, error) {
+	n, err := obj.ReadCloser.Read(p)
+	if n > 0 {
+		meta := obj.meta
+		obj.tap(&meta, p[:n], obj.direction)
+		obj.meta.Offset += int64(n)
+	}
+	if err == io.EOF && !obj.meta.Done {
+		obj.meta.Done = true
+		meta := obj.meta
+		obj.tap(&meta, nil, obj.direction)
+	}
+	return n, err
+}
+
+// http1.1 请求body 回调
+func (obj *Client) tapRequest(client *ProxyConn, req *http.Request) {
+	if obj.bodyTap == nil || req.Body == nil || req.Body == http.NoBody {
+		return
+	}
+	req.Body = &tapBody{ReadCloser: req.Body, tap: obj.bodyTap, direction: BodyRequest, meta: BodyMeta{
+		Session: client.option.session,
+		Method:  req.Method,
+		Url:     req.URL.String(),
+		Header:  req.Header,
+	}}
+}
+
+// http1.1 响应body 回调
+func (obj *Client) tapResponse(client *ProxyConn, req *http.Request, rsp *http.Response) {
+	if obj.bodyTap == nil || rsp.Body == nil || rsp.Body == http.NoBody {
+		return
+	}
+	rsp.Body = &tapBody{ReadCloser: rsp.Body, tap: obj.bodyTap, direction: BodyResponse, meta: BodyMeta{
+		Session:    client.option.session,
+		Method:     req.Method,
+		Url:        req.URL.String(),
+		StatusCode: rsp.StatusCode,
+		Header:     rsp.Header,
+	}}
+}
+
+const (
+	h2FrameData         = 0x0
+	h2FrameHeaders      = 0x1
+	h2FrameRstStream    = 0x3
+	h2FrameSettings     = 0x4
+	h2FrameGoAway       = 0x7
+	h2FrameContinuation = 0x9
+
+	h2FlagEndStream  = 0x1
+	h2FlagAck        = 0x1
+	h2FlagEndHeaders = 0x4
+	h2FlagPadded     = 0x8
+	h2FlagPriority   = 0x20
+
+	h2SettingHeaderTableSize = 0x1
+
+	h2TapMaxStreams = 1000 //同时记录的流数量,超过时淘汰最早的流
+)
+
+type h2TapStream struct {
+	meta [3]*BodyMeta //按方向
+}
+
+// 单方向的头部解析状态
+type h2TapHeader struct {
+	decoder   *hpack.Decoder
+	block     []byte
+	streamId  uint32
+	endStream bool
+}
+
+// 解析http2 帧,对DATA 帧调用回调
+type h2Tap struct {
+	tap     BodyTap
+	session *Session
+	headers [3]*h2TapHeader
+	lock    sync.Mutex
+	streams map[uint32]*h2TapStream
+}
+
+func newH2Tap(tap BodyTap, session *Session) *h2Tap {
+	return &h2Tap{
+		tap:     tap,
+		session: session,
+		headers: [3]*h2TapHeader{
+			BodyRequest:  {decoder: hpack.NewDecoder(4096, nil)},
+			BodyResponse: {decoder: hpack.NewDecoder(4096, nil)},
+		},
+		streams: make(map[uint32]*h2TapStream),
+	}
+}
+
+// 去掉填充
+func h2Unpad(flags byte, payload []byte) []byte {
+	if flags&h2FlagPadded == 0 {
+		return payload
+	}
+	if len(payload) == 0 || int(payload[0]) >= len(payload) {
+		return nil
+	}
+	return payload[1 : len(payload)-int(payload[0])]
+}
+
+// 处理一个完整的帧,帧头9个字节
+func (obj *h2Tap) frame(data []byte, direction BodyDirection) {
+	if obj == nil || len(data) < 9 {
+		return
+	}
+	length := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+	if len(data) != 9+length {
+		return
+	}
+	frameType, flags := data[3], data[4]
+	streamId := binary.BigEndian.Uint32(data[5:9]) & 0x7fffffff
+	payload := data[9:]
+	switch frameType {
+	case h2FrameData:
+		obj.data(streamId, flags, h2Unpad(flags, payload), direction)
+	case h2FrameHeaders:
+		payload = h2Unpad(flags, payload)
+		if flags&h2FlagPriority != 0 {
+			if len(payload) < 5 {
+				return
+			}
+			payload = payload[5:]
+		}
+		header := obj.headers[direction]
+		header.block = append(header.block[:0], payload...)
+		header.streamId = streamId
+		header.endStream = flags&h2FlagEndStream != 0
+		if flags&h2FlagEndHeaders != 0 {
+			obj.decodeHeader(direction)
+		}
+	case h2FrameContinuation:
+		header := obj.headers[direction]
+		if header.streamId != streamId {
+			return
+		}
+		header.block = append(header.block, payload...)
+		if flags&h2FlagEndHeaders != 0 {
+			obj.decodeHeader(direction)
+		}
+	case h2FrameRstStream:
+		obj.lock.Lock()
+		delete(obj.streams, streamId)
+		obj.lock.Unlock()
+	case h2FrameGoAway: //大于last stream id 的流不会再被处理
+		if len(payload) < 8 {
+			return
+		}
+		lastStreamId := binary.BigEndian.Uint32(payload) & 0x7fffffff
+		obj.lock.Lock()
+		for id := range obj.streams {
+			if id > lastStreamId {
+				delete(obj.streams, id)
+			}
+		}
+		obj.lock.Unlock()
+	case h2FrameSettings:
+		if flags&h2FlagAck != 0 {
+			return
+		}
+		peer := BodyResponse //设置的是发送方的解码表大小,作用于对端发送的头部
+		if direction == BodyResponse {
+			peer = BodyRequest
+		}
+		for ; len(payload) >= 6; payload = payload[6:] {
+			if binary.BigEndian.Uint16(payload) == h2SettingHeaderTableSize {
+				obj.headers[peer].decoder.SetAllowedMaxDynamicTableSize(binary.BigEndian.Uint32(payload[2:]))
+			}
+		}
+	}
+}
+
+// 获取或创建流的记录,调用前加锁,超过数量限制时淘汰id 最小的流
+func (obj *h2Tap) stream(streamId uint32) *h2TapStream {
+	stream := obj.streams[streamId]
+	if stream != nil {
+		return stream
+	}
+	if len(obj.streams) >= h2TapMaxStreams {
+		oldest := streamId
+		for id := range obj.streams {
+			if id < oldest {
+				oldest = id
+			}
+		}
+		delete(obj.streams, oldest)
+	}
+	stream = new(h2TapStream)
+	obj.streams[streamId] = stream
+	return stream
+}
+
+// 解析请求头或响应头,压缩状态需要解析所有头部块
+func (obj *h2Tap) decodeHeader(direction BodyDirection) {
+	header := obj.headers[direction]
+	fields, err := header.decoder.DecodeFull(header.block)
+	header.block = header.block[:0]
+	if err != nil {
+		return
+	}
+	meta := &BodyMeta{Session: obj.session, StreamId: header.streamId, Header: make(http.Header)}
+	var scheme, authority, path string
+	for _, field := range fields {
+		switch field.Name {
+		case ":method":
+			meta.Method = field.Value
+		case ":scheme":
+			scheme = field.Value
+		case ":authority":
+			authority = field.Value
+		case ":path":
+			path = field.Value
+		case ":status":
+			meta.StatusCode, _ = strconv.Atoi(field.Value)
+		default:
+			meta.Header.Add(field.Name, field.Value)
+		}
+	}
+	if meta.StatusCode >= 100 && meta.StatusCode < 200 { //1xx 响应
+		return
+	}
+	obj.lock.Lock()
+	stream := obj.stream(header.streamId)
+	if stream.meta[direction] == nil { //trailer 不覆盖
+		if request := stream.meta[BodyRequest]; direction == BodyResponse && request != nil {
+			meta.Method, meta.Url = request.Method, request.Url
+		} else if authority != "" {
+			meta.Url = scheme + "://" + authority + path
+		}
+		stream.meta[direction] = meta
+	}
+	obj.lock.Unlock()
+	if header.endStream {
+		obj.data(header.streamId, h2FlagEndStream, nil, direction)
+	}
+}
+func (obj *h2Tap) data(streamId uint32, flags byte, chunk []byte, direction BodyDirection) {
+	obj.lock.Lock()
+	stream := obj.streams[streamId] //DATA 帧之前必有HEADERS,已结束或淘汰的流不再记录
+	if stream == nil {
+		obj.lock.Unlock()
+		return
+	}
+	if stream.meta[direction] == nil {
+		stream.meta[direction] = &BodyMeta{Session: obj.session, StreamId: streamId}
+	}
+	meta := *stream.meta[direction]
+	stream.meta[direction].Offset += int64(len(chunk))
+	endStream := flags&h2FlagEndStream != 0
+	if endStream && direction == BodyResponse { //响应结束后交换完成,请求没有结束也删除
+		delete(obj.streams, streamId)
+	}
+	obj.lock.Unlock()
+	if len(chunk) > 0 {
+		obj.tap(&meta, chunk, direction)
+		meta.Offset += int64(len(chunk))
+	}
+	if endStream && meta.Offset > 0 { //没有body 的请求不回调
+		meta.Done = true
+		obj.tap(&meta, nil, direction)
+	}
+}
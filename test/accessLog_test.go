@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gospider007/proxy"
+)
+
+type chanWriter chan []byte
+
+func (obj chanWriter) Write(p []byte) (int, error) {
+	obj <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+func TestProxyAccessLog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	logs := make(chanWriter, 10)
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		Logger:    proxy.NewJsonLogger(logs),
+		RequestCallBack: func(r1 *http.Request, r2 *http.Response) error {
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	statusCode, _, err := rawProxyGet(proCli.Addr(), server.URL+"/log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 200 {
+		t.Fatal("请求失败: ", statusCode)
+	}
+	select {
+	case line := <-logs:
+		var accessLog proxy.AccessLog
+		if err = json.Unmarshal(line, &accessLog); err != nil {
+			t.Fatal(err)
+		}
+		if accessLog.Method != "GET" || accessLog.Status != 200 || accessLog.Url != server.URL+"/log" || accessLog.Protocol != "http" {
+			t.Fatal("访问日志错误: ", string(line))
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("没有访问日志")
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gospider007/proxy"
+)
+
+func TestProxyArchive(t *testing.T) {
+	var count int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		fmt.Fprintf(w, "count=%d", count)
+	}))
+	dir := t.TempDir()
+	href := server.URL + "/page?id=1&t=123"
+	recordCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		Archive:   &proxy.ArchiveOption{Mode: proxy.ArchiveRecord, Dir: dir},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go recordCli.Run()
+	statusCode, body, err := rawProxyGet(recordCli.Addr(), href)
+	recordCli.Close()
+	server.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 200 || body != "count=1" {
+		t.Fatal("录制失败: ", statusCode, body)
+	}
+	replayCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		Archive: &proxy.ArchiveOption{
+			Mode:        proxy.ArchiveReplay,
+			Dir:         dir,
+			IgnoreQuery: []string{"t"},
+			Strict:      true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayCli.Close()
+	go replayCli.Run()
+	statusCode, body, err = rawProxyGet(replayCli.Addr(), server.URL+"/page?t=456&id=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 200 || body != "count=1" {
+		t.Fatal("回放失败: ", statusCode, body)
+	}
+	statusCode, _, err = rawProxyGet(replayCli.Addr(), server.URL+"/page?id=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 502 {
+		t.Fatal("严格模式没有匹配的请求应该返回502: ", statusCode)
+	}
+}
+
+// 流式响应和超过大小限制的响应直接转发,不录制
+func TestProxyArchiveStream(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sse":
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: 1\n\n"))
+			w.(http.Flusher).Flush()
+			<-done
+		case "/big": //chunked 编码,边转发边判断大小
+			w.Write([]byte(strings.Repeat("a", 5000)))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(strings.Repeat("a", 5000)))
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+	dir := t.TempDir()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		Archive:   &proxy.ArchiveOption{Mode: proxy.ArchiveRecord, Dir: dir, MaxBodySize: 1000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	conn, err := net.Dial("tcp", proCli.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	if _, err = fmt.Fprintf(conn, "GET %s/sse HTTP/1.1\r\nHost: %s\r\n\r\n", server.URL, server.Listener.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(rsp.Body).ReadString('\n')
+	if err != nil || line != "data: 1\n" {
+		t.Fatal("sse 没有及时转发: ", line, err)
+	}
+	statusCode, body, err := rawProxyGet(proCli.Addr(), server.URL+"/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 200 || len(body) != 10000 {
+		t.Fatal("大文件转发失败: ", statusCode, len(body))
+	}
+	if statusCode, body, err = rawProxyGet(proCli.Addr(), server.URL+"/small"); err != nil || body != "ok" {
+		t.Fatal("请求失败: ", statusCode, body, err)
+	}
+	fileNames, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fileNames) != 1 {
+		t.Fatal("只应该录制小的响应: ", len(fileNames))
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gospider007/gtls"
+	"github.com/gospider007/proxy"
+)
+
+func TestProxyCa(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello ca"))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:  "127.0.0.1:0",
+		Usr:   "admin",
+		Pwd:   "123",
+		CaDir: dir,
+		Middlewares: []proxy.Middleware{proxy.MiddlewareFunc{
+			Response: func(req *http.Request, rsp *http.Response) error {
+				rsp.Header.Set("X-Mitm", "1")
+				return nil
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	crtData, err := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "ca.key")); err != nil {
+		t.Fatal(err)
+	}
+	//重新加载是同一个根证书
+	ca, err := proxy.LoadOrCreateCa(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ca.Der(), proCli.Ca().Der()) {
+		t.Fatal("根证书不一致")
+	}
+	//从监听地址下载根证书,不需要验证
+	resp, err := http.Get("http://" + proCli.Addr() + proxy.CaPemPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || !bytes.Equal(content, crtData) {
+		t.Fatal("下载根证书失败: ", resp.StatusCode)
+	}
+	resp, err = http.Get("http://" + proCli.Addr() + proxy.CaDerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, proCli.Ca().Der()) {
+		t.Fatal("下载der 根证书失败")
+	}
+	//信任根证书后中间人解密
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(crtData) {
+		t.Fatal("根证书格式错误")
+	}
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(&url.URL{Scheme: "http", User: url.UserPassword("admin", "123"), Host: proCli.Addr()}),
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+	}}
+	resp, err = client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hello ca" || resp.Header.Get("X-Mitm") != "1" {
+		t.Fatal("中间人解密失败: ", string(content))
+	}
+}
+
+// 没有设置根证书时用到才加载用户配置目录的根证书,同时创建只生成一个
+func TestProxyCaDefault(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	dir, err := proxy.DefaultCaDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proClis := make([]*proxy.Client, 5)
+	for i := range proClis {
+		if proClis[i], err = proxy.NewClient(nil, proxy.ClientOption{Addr: "127.0.0.1:0"}); err != nil {
+			t.Fatal(err)
+		}
+		proClis[i].Close()
+	}
+	if _, err = os.Stat(filepath.Join(dir, "ca.crt")); !os.IsNotExist(err) {
+		t.Fatal("没有使用中间人时不应该创建根证书: ", err)
+	}
+	var wg sync.WaitGroup
+	cas := make([]*proxy.Ca, len(proClis))
+	for i, proCli := range proClis {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cas[i] = proCli.Ca()
+		}()
+	}
+	wg.Wait()
+	ca, err := proxy.LoadOrCreateCa(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cas {
+		if c == nil || !bytes.Equal(c.Der(), ca.Der()) {
+			t.Fatal("根证书不一致")
+		}
+	}
+}
+
+// 内置根证书的私钥是公开的,不提供下载
+func TestProxyCaBuiltin(t *testing.T) {
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		CaCrt:     gtls.CrtFile,
+		CaKey:     gtls.KeyFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	resp, err := http.Get("http://" + proCli.Addr() + proxy.CaPemPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 403 {
+		t.Fatal("内置根证书不应该提供下载: ", resp.StatusCode)
+	}
+}
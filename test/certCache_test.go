@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gospider007/proxy"
+)
+
+func TestProxyCertCache(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		CertCache: proxy.CertCacheOption{
+			MaxSize:  1,
+			Wildcard: true,
+			KeyType:  proxy.CertKeyEd25519,
+		},
+		RequestCallBack: func(r1 *http.Request, r2 *http.Response) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	pool := x509.NewCertPool()
+	pool.AddCert(proCli.Ca().Certificate())
+	for _, serverName := range []string{"a.example.com", "b.example.com", "c.other.org", "a.co.uk"} {
+		client := &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyURL(&url.URL{Scheme: "http", Host: proCli.Addr()}),
+			TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: serverName},
+		}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		leaf := resp.TLS.PeerCertificates[0]
+		if leaf.PublicKeyAlgorithm != x509.Ed25519 {
+			t.Fatal("证书私钥类型错误: ", leaf.PublicKeyAlgorithm)
+		}
+		switch serverName {
+		case "a.example.com", "b.example.com":
+			if leaf.DNSNames[0] != "*.example.com" {
+				t.Fatal("没有签发通配符证书: ", leaf.DNSNames)
+			}
+		case "a.co.uk":
+			if leaf.DNSNames[0] != "a.co.uk" {
+				t.Fatal("公共后缀不能签发通配符证书: ", leaf.DNSNames)
+			}
+		}
+	}
+	stats := proCli.CertCacheStats()
+	if stats.Hits != 1 || stats.Misses != 3 || stats.Evictions != 2 || stats.Size != 1 {
+		t.Fatalf("缓存统计错误: %+v", stats)
+	}
+}
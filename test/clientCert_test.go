@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gospider007/proxy"
+)
+
+// 签发客户端证书
+func createClientCert(t *testing.T, commonName string) (*x509.Certificate, tls.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "client ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestProxyClientCert(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	ca, cert := createClientCert(t, "alice")
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	logs := make(chanWriter, 10)
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:       "127.0.0.1:0",
+		ClientCert: &proxy.ClientCertOption{Roots: roots},
+		Logger:     proxy.NewJsonLogger(logs),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	proxyRoots := x509.NewCertPool()
+	proxyRoots.AddCert(proCli.Ca().Certificate())
+	get := func(certs []tls.Certificate) (int, error) {
+		client := &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyURL(&url.URL{Scheme: "https", Host: proCli.Addr()}),
+			TLSClientConfig: &tls.Config{RootCAs: proxyRoots, Certificates: certs},
+		}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return 0, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+	statusCode, err := get([]tls.Certificate{cert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 200 {
+		t.Fatal("客户端证书认证失败: ", statusCode)
+	}
+	select {
+	case content := <-logs:
+		var accessLog proxy.AccessLog
+		if err = json.Unmarshal(content, &accessLog); err != nil {
+			t.Fatal(err)
+		}
+		if accessLog.User != "alice" {
+			t.Fatal("证书用户错误: ", string(content))
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("没有访问日志")
+	}
+	//没有证书需要账号密码认证
+	if statusCode, err = get(nil); err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 407 {
+		t.Fatal("没有证书应该认证失败: ", statusCode)
+	}
+}
+
+// 没有开启客户端证书认证时,不在白名单的连接不等待读取直接关闭
+func TestProxyWhiteReject(t *testing.T) {
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr: "127.0.0.1:0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	conn, err := net.Dial("tcp", proCli.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+	if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("不在白名单的连接应该直接关闭: ", err)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gospider007/proxy"
+)
+
+func TestProxyClientHello(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	hellos := make(chan *proxy.ClientHello, 1)
+	logs := make(chanWriter, 10)
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		Logger:    proxy.NewJsonLogger(logs),
+		ClientHelloCallBack: func(session *proxy.Session, hello *proxy.ClientHello) error {
+			hellos <- hello
+			return nil
+		},
+		RequestCallBack: func(r1 *http.Request, r2 *http.Response) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	pool := x509.NewCertPool()
+	pool.AddCert(proCli.Ca().Certificate())
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:             http.ProxyURL(&url.URL{Scheme: "http", Host: proCli.Addr()}),
+		TLSClientConfig:   &tls.Config{RootCAs: pool, ServerName: "a.test.org", NextProtos: []string{"http/1.1"}},
+		DisableKeepAlives: true,
+	}}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	var hello *proxy.ClientHello
+	select {
+	case hello = <-hellos:
+	default:
+		t.Fatal("没有握手消息回调")
+	}
+	if hello.ServerName != "a.test.org" || len(hello.Raw) == 0 {
+		t.Fatal("握手消息错误: ", hello.ServerName)
+	}
+	if !strings.HasPrefix(hello.Ja3, "771,") || len(hello.Ja3Hash) != 32 {
+		t.Fatal("ja3 错误: ", hello.Ja3)
+	}
+	if !regexp.MustCompile(`^t13d\d{4}h1_[0-9a-f]{12}_[0-9a-f]{12}$`).MatchString(hello.Ja4) {
+		t.Fatal("ja4 错误: ", hello.Ja4)
+	}
+	//会话日志记录指纹
+	for {
+		select {
+		case content := <-logs:
+			var accessLog proxy.AccessLog
+			if err = json.Unmarshal(content, &accessLog); err != nil {
+				t.Fatal(err)
+			}
+			if accessLog.Ja4 == "" {
+				continue
+			}
+			if accessLog.Ja4 != hello.Ja4 || accessLog.Ja3 != hello.Ja3Hash {
+				t.Fatal("访问日志指纹错误: ", string(content))
+			}
+			return
+		case <-time.After(time.Second * 3):
+			t.Fatal("访问日志没有指纹")
+		}
+	}
+}
+
+// chrome 的握手消息,扩展和加密套件与ja4 文档的示例相同
+const chromeHello = "1603010147010001430303000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f00200a0a130113021303c02bc02fc02cc030cca9cca8c013c014009c009d002f0035010000da2a2a00000000000f000d00000a612e746573742e6f726700170000ff01000100000a000a00083a3a001d00170018000b00020100002300000010000e000c02683208687474702f312e31000500050100000000000d0012001004030804040105030805050108060601001200000033002b00293a3a000100001d0020000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f002d00020101002b0007065a5a03040303001b00030200024469000500030268321a1a000100001500140000000000000000000000000000000000000000"
+
+// 签名算法中带GREASE 的chrome 握手消息,ja4 和chromeHello 相同
+const chromeGreaseHello = "1603010149010001450303000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f00200a0a130113021303c02bc02fc02cc030cca9cca8c013c014009c009d002f0035010000dc2a2a00000000000f000d00000a612e746573742e6f726700170000ff01000100000a000a00083a3a001d00170018000b00020100002300000010000e000c02683208687474702f312e31000500050100000000000d001400120a0a04030804040105030805050108060601001200000033002b00293a3a000100001d0020000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f002d00020101002b0007065a5a03040303001b00030200024469000500030268321a1a000100001500140000000000000000000000000000000000000000"
+
+// ja3 文档示例的握手消息
+const ja3Hello = "160301006a010000660301000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f000018002f00350005000ac009c00ac013c0140032003800130004010000250000000f000d00000a612e746573742e6f7267000a00080006001700180019000b00020100"
+
+// ja4 文档公布的指纹: t13d1516h2_8daaf6152771_e5627efa2ab1
+const chromeJa4 = "t13d1516h2_8daaf6152771_e5627efa2ab1"
+
+func TestClientHelloVector(t *testing.T) {
+	raw, _ := hex.DecodeString(chromeHello)
+	hello, err := proxy.ParseClientHello(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hello.Ja4 != chromeJa4 {
+		t.Fatal("ja4 错误: ", hello.Ja4)
+	}
+	if hello.ServerName != "a.test.org" || !slices.Equal(hello.Protocols, []string{"h2", "http/1.1"}) {
+		t.Fatal("握手消息错误: ", hello.ServerName, hello.Protocols)
+	}
+	raw, _ = hex.DecodeString(chromeGreaseHello)
+	if hello, err = proxy.ParseClientHello(raw); err != nil {
+		t.Fatal(err)
+	}
+	if hello.Ja4 != chromeJa4 {
+		t.Fatal("签名算法的GREASE 没有去掉: ", hello.Ja4)
+	}
+	raw, _ = hex.DecodeString(ja3Hello)
+	if hello, err = proxy.ParseClientHello(raw); err != nil {
+		t.Fatal(err)
+	}
+	//ja3 文档公布的指纹
+	if hello.Ja3 != "769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0" {
+		t.Fatal("ja3 错误: ", hello.Ja3)
+	}
+	if hello.Ja3Hash != "ada70206e40642a3e4461f35503241d5" {
+		t.Fatal("ja3 hash 错误: ", hello.Ja3Hash)
+	}
+}
+
+// 握手消息分成多个tls 记录或超过4096 字节时也能解析
+func TestProxyClientHelloSplit(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	hellos := make(chan *proxy.ClientHello, 1)
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		ClientHelloCallBack: func(session *proxy.Session, hello *proxy.ClientHello) error {
+			hellos <- hello
+			return nil
+		},
+		RequestCallBack: func(r1 *http.Request, r2 *http.Response) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	receive := func() *proxy.ClientHello {
+		select {
+		case hello := <-hellos:
+			return hello
+		case <-time.After(time.Second * 3):
+			t.Fatal("没有握手消息回调")
+		}
+		return nil
+	}
+	//握手消息分成两个tls 记录
+	conn, err := net.Dial("tcp", proCli.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	host := server.Listener.Addr().String()
+	conn.Write([]byte("CONNECT " + host + " HTTP/1.1\r\nHost: " + host + "\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	raw, _ := hex.DecodeString(chromeHello)
+	msg := raw[5:]
+	for _, fragment := range [][]byte{msg[:100], msg[100:]} {
+		conn.Write(append([]byte{22, 3, 1, byte(len(fragment) >> 8), byte(len(fragment))}, fragment...))
+	}
+	if hello := receive(); hello.Ja4 != chromeJa4 {
+		t.Fatal("分片的握手消息ja4 错误: ", hello.Ja4)
+	}
+	//超过4096 字节的握手消息
+	pool := x509.NewCertPool()
+	pool.AddCert(proCli.Ca().Certificate())
+	protocols := make([]string, 30)
+	for i := range protocols {
+		protocols[i] = strings.Repeat("x", 200)
+	}
+	protocols = append(protocols, "http/1.1")
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(&url.URL{Scheme: "http", Host: proCli.Addr()}),
+		TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "a.test.org", NextProtos: protocols},
+	}}
+	if resp, err = client.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if hello := receive(); len(hello.Raw) <= 4096 || len(hello.Protocols) != len(protocols) {
+		t.Fatal("大的握手消息解析错误: ", len(hello.Raw))
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gospider007/proxy"
+	xproxy "golang.org/x/net/proxy"
+)
+
+func TestProxyForwardedHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "X-Resp-Hop")
+		w.Header().Set("X-Resp-Hop", "1")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		fmt.Fprintf(w, "%s|%s|%s|%s|%s|%s", r.Header.Get("Proxy-Authorization"), r.Header.Get("Proxy-Connection"), r.Header.Get("X-Hop"), r.Header.Get("Via"), r.Header.Get("X-Forwarded-For"), r.Header.Get("Forwarded"))
+	}))
+	defer server.Close()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:          "127.0.0.1:0",
+		Usr:           "usr",
+		Pwd:           "pwd",
+		Via:           "gospider",
+		XForwardedFor: true,
+		Forwarded:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	statusCode, body, err := rawProxyGet(proCli.Addr(), server.URL+"/",
+		"Proxy-Authorization: Basic "+base64.StdEncoding.EncodeToString([]byte("usr:pwd"))+"\r\n",
+		"Proxy-Connection: keep-alive\r\n",
+		"Connection: X-Hop\r\n",
+		"X-Hop: 1\r\n",
+		"X-Forwarded-For: 10.0.0.1\r\n",
+		"X-Forwarded-For: 10.0.0.2\r\n",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 200 {
+		t.Fatal("请求失败: ", statusCode)
+	}
+	host := server.Listener.Addr().String()
+	if want := "|||1.1 gospider|10.0.0.1, 10.0.0.2, 127.0.0.1|for=127.0.0.1;host=\"" + host + "\";proto=http"; body != want {
+		t.Fatal("转发请求头错误: ", body)
+	}
+	//响应也删除逐跳头
+	conn, err := net.Dial("tcp", proCli.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET %s/ HTTP/1.1\r\nHost: %s\r\nProxy-Authorization: Basic %s\r\n\r\n", server.URL, host, base64.StdEncoding.EncodeToString([]byte("usr:pwd")))
+	rsp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	if rsp.Header.Get("X-Resp-Hop") != "" || rsp.Header.Get("Keep-Alive") != "" || rsp.Header.Get("Connection") != "" {
+		t.Fatal("响应的逐跳头没有删除: ", rsp.Header)
+	}
+}
+
+// socks5 隧道中不是http 的协议直接转发
+func TestProxySocksTunnel(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte("SSH-2.0-server\r\n"))
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					conn.Write([]byte(line))
+				}
+			}()
+		}
+	}()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	dialer, err := xproxy.SOCKS5("tcp", proCli.Addr(), nil, xproxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	conn.Write([]byte("SSH-2.0-client\r\n"))
+	reader := bufio.NewReader(conn)
+	for _, want := range []string{"SSH-2.0-server\r\n", "SSH-2.0-client\r\n"} {
+		if line, err := reader.ReadString('\n'); err != nil || line != want {
+			t.Fatal("转发失败: ", line, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gospider007/proxy"
+)
+
+func TestProxyHar(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		gw.Write([]byte("hello har"))
+		gw.Close()
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		Har:       &proxy.HarOption{Dir: dir},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	statusCode, _, err := rawProxyGet(proCli.Addr(), server.URL+"/har?a=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 200 {
+		t.Fatal("请求失败: ", statusCode)
+	}
+	var files []string
+	for i := 0; i < 30 && len(files) == 0; i++ {
+		time.Sleep(time.Millisecond * 100)
+		files, _ = filepath.Glob(filepath.Join(dir, "*.har"))
+	}
+	if len(files) != 1 {
+		t.Fatal("没有har 文件")
+	}
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var har proxy.Har
+	if err = json.Unmarshal(content, &har); err != nil {
+		t.Fatal(err)
+	}
+	if len(har.Log.Entries) != 1 {
+		t.Fatal("har 条数错误: ", string(content))
+	}
+	entry := har.Log.Entries[0]
+	if entry.Request.Url != server.URL+"/har?a=1" || entry.Response.Status != 200 || entry.Response.Content.Text != "hello har" {
+		t.Fatal("har 内容错误: ", string(content))
+	}
+}
+
+// 只有RequestCallBack 时中间人两端保持h2,记录har 时强制http1.1
+func TestProxyHarHttp1(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	for _, har := range []bool{false, true} {
+		option := proxy.ClientOption{
+			Addr:            "127.0.0.1:0",
+			DisVerify:       true,
+			RequestCallBack: func(r1 *http.Request, r2 *http.Response) error { return nil },
+		}
+		proto := "HTTP/2.0"
+		if har {
+			option.Har = &proxy.HarOption{Dir: t.TempDir()}
+			proto = "HTTP/1.1"
+		}
+		proCli, err := proxy.NewClient(nil, option)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer proCli.Close()
+		go proCli.Run()
+		pool := x509.NewCertPool()
+		pool.AddCert(proCli.Ca().Certificate())
+		client := &http.Client{Transport: &http.Transport{
+			Proxy:             http.ProxyURL(&url.URL{Scheme: "http", Host: proCli.Addr()}),
+			TLSClientConfig:   &tls.Config{RootCAs: pool},
+			ForceAttemptHTTP2: true,
+		}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Proto != proto || string(body) != proto {
+			t.Fatal("协议错误: ", har, resp.Proto, string(body))
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gospider007/proxy"
+)
+
+func TestProxyHeaderRules(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "test-server")
+		fmt.Fprintf(w, "%s|%s|%s", r.Header.Get("X-Token"), r.Header.Get("Proxy-Foo"), r.Header.Get("User-Agent"))
+	}))
+	defer server.Close()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		HeaderRules: []proxy.HeaderRule{
+			{Action: proxy.HeaderSet, Name: "x-token", Value: "abc"},
+			{Action: proxy.HeaderDel, Name: "Proxy-Foo"},
+			{Action: proxy.HeaderReplace, Name: "User-Agent", Regexp: `Chrome/(\d+)`, Value: "Chrome/$1.0"},
+			{Match: proxy.UrlMatch{Path: "/none"}, Action: proxy.HeaderSet, Name: "X-Token", Value: "none"},
+			{Response: true, Action: proxy.HeaderDel, Name: "Server"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	conn, err := net.Dial("tcp", proCli.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET %s/ HTTP/1.1\r\nConnection: close\r\nProxy-Foo: bar\r\nUser-Agent: Chrome/120\r\n\r\n", server.URL)
+	rsp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	body, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "abc||Chrome/120.0" {
+		t.Fatal("修改请求头失败: ", string(body))
+	}
+	if rsp.Header.Get("Server") != "" {
+		t.Fatal("修改响应头失败: ", rsp.Header)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+)
+
+// 记录每个连接收到的tls 握手消息
+type helloListener struct {
+	net.Listener
+	lock   sync.Mutex
+	hellos [][]byte
+}
+
+func (obj *helloListener) Accept() (net.Conn, error) {
+	conn, err := obj.Listener.Accept()
+	if err != nil {
+		return conn, err
+	}
+	return &helloConn{Conn: conn, listener: obj}, nil
+}
+
+type helloConn struct {
+	net.Conn
+	listener *helloListener
+	data     []byte
+	done     bool
+}
+
+func (obj *helloConn) Read(b []byte) (int, error) {
+	n, err := obj.Conn.Read(b)
+	if !obj.done {
+		obj.data = append(obj.data, b[:n]...)
+		if len(obj.data) >= 5 && len(obj.data) >= 5+int(binary.BigEndian.Uint16(obj.data[3:5])) {
+			obj.done = true
+			obj.listener.lock.Lock()
+			obj.listener.hellos = append(obj.listener.hellos, obj.data[:5+int(binary.BigEndian.Uint16(obj.data[3:5]))])
+			obj.listener.lock.Unlock()
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gospider007/proxy"
+)
+
+type lockBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (obj *lockBuffer) Write(p []byte) (int, error) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	return obj.buf.Write(p)
+}
+func (obj *lockBuffer) String() string {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	return obj.buf.String()
+}
+
+func TestProxyKeyLog(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	keyLog := new(lockBuffer)
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:            "127.0.0.1:0",
+		DisVerify:       true,
+		KeyLogWriter:    keyLog,
+		RequestCallBack: func(r1 *http.Request, r2 *http.Response) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	pool := x509.NewCertPool()
+	pool.AddCert(proCli.Ca().Certificate())
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(&url.URL{Scheme: "http", Host: proCli.Addr()}),
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+	}}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	//客户端和服务端两个连接的client random
+	randoms := map[string]bool{}
+	for _, line := range strings.Split(keyLog.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) == 3 && fields[0] == "CLIENT_TRAFFIC_SECRET_0" {
+			randoms[fields[1]] = true
+		}
+	}
+	if len(randoms) != 2 {
+		t.Fatal("密钥日志错误: ", keyLog.String())
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gospider007/proxy"
+	"github.com/gospider007/tools"
+)
+
+// 通过代理发送一个GET 请求,返回状态码和body
+func rawProxyGet(proxyAddr string, href string, headers ...string) (int, string, error) {
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		return 0, "", err
+	}
+	defer conn.Close()
+	if _, err = fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nConnection: close\r\n%s\r\n", href, strings.Join(headers, "")); err != nil {
+		return 0, "", err
+	}
+	rsp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return 0, "", err
+	}
+	defer rsp.Body.Close()
+	body, err := io.ReadAll(rsp.Body)
+	return rsp.StatusCode, string(body), err
+}
+
+func TestProxyLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		Limit: &proxy.LimitOption{
+			ConnRate:  0.1,
+			ConnBurst: 1,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	statusCode, _, err := rawProxyGet(proCli.Addr(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 200 {
+		t.Fatal("第一个连接应该成功: ", statusCode)
+	}
+	statusCode, _, err = rawProxyGet(proCli.Addr(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 429 {
+		t.Fatal("第二个连接应该被限流: ", statusCode)
+	}
+}
+
+// 没有验证的用户名不按用户限流
+func TestProxyLimitUser(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		Limit: &proxy.LimitOption{
+			ConnRate:  0.1,
+			ConnBurst: 1,
+			ByUser:    true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	for i, usr := range []string{"a", "b"} {
+		statusCode, _, err := rawProxyGet(proCli.Addr(), server.URL, "Proxy-Authorization: Basic "+tools.Base64Encode(usr+":x")+"\r\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 && statusCode != 200 {
+			t.Fatal("第一个连接应该成功: ", statusCode)
+		}
+		if i == 1 && statusCode != 429 {
+			t.Fatal("换用户名不应该绕过限流: ", statusCode)
+		}
+	}
+}
+
+func TestProxyLimitRate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		fmt.Fprint(w, n)
+	}))
+	defer server.Close()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		Limit: &proxy.LimitOption{
+			UpRate: 100000,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	conn, err := net.Dial("tcp", proCli.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	content := bytes.Repeat([]byte("a"), 200000)
+	startTime := time.Now()
+	go fmt.Fprintf(conn, "POST %s HTTP/1.1\r\nHost: %s\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", server.URL, server.Listener.Addr(), len(content), content)
+	rsp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != fmt.Sprint(len(content)) {
+		t.Fatal("请求body 错误: ", string(body))
+	}
+	//突发100000 字节,剩余100000 字节需要等待1秒
+	if elapsed := time.Since(startTime); elapsed < time.Millisecond*800 {
+		t.Fatal("请求body 没有限速: ", elapsed)
+	}
+}
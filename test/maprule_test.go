@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gospider007/proxy"
+)
+
+func TestProxyMapRule(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("remote " + r.URL.Path))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app.js"), []byte("local js"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		MapLocal: []proxy.MapLocal{
+			{Match: proxy.UrlMatch{Host: "static.test", Path: "/js/*"}, Path: dir},
+		},
+		MapRemote: []proxy.MapRemote{
+			{Match: proxy.UrlMatch{Host: "api.test", Path: "/v1/*"}, To: server.URL + "/v2"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	statusCode, body, err := rawProxyGet(proCli.Addr(), "http://static.test/js/app.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 200 || body != "local js" {
+		t.Fatal("本地映射失败: ", statusCode, body)
+	}
+	statusCode, _, err = rawProxyGet(proCli.Addr(), "http://static.test/js/none.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 404 {
+		t.Fatal("本地文件不存在应该返回404: ", statusCode)
+	}
+	statusCode, body, err = rawProxyGet(proCli.Addr(), "http://api.test/v1/users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 200 || body != "remote /v2/users" {
+		t.Fatal("远程映射失败: ", statusCode, body)
+	}
+}
+
+// 改写的请求切换服务端连接后,后续没有改写的请求要回到原来的目标地址
+func TestProxyMapRemoteSwitch(t *testing.T) {
+	serverA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("a " + r.URL.Path))
+	}))
+	defer serverA.Close()
+	serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("b " + r.URL.Path))
+	}))
+	defer serverB.Close()
+	hostA := serverA.Listener.Addr().String()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		MapRemote: []proxy.MapRemote{
+			{Match: proxy.UrlMatch{Host: hostA, Path: "/remote/*"}, To: serverB.URL + "/b"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	conn, err := net.Dial("tcp", proCli.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", hostA, hostA)
+	rsp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.StatusCode != 200 {
+		t.Fatal("connect 失败: ", rsp.StatusCode)
+	}
+	for _, item := range [][2]string{{"/remote/x", "b /b/x"}, {"/local", "a /local"}, {"/remote/y", "b /b/y"}} {
+		fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\n\r\n", item[0], hostA)
+		rsp, err = http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(rsp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != item[1] {
+			t.Fatal("请求发往了错误的地址: ", item[0], string(body))
+		}
+	}
+	//隧道中的Host 和connect 的地址不一致时,仍然发往connect 的地址
+	fmt.Fprintf(conn, "GET /spoof HTTP/1.1\r\nHost: %s\r\n\r\n", serverB.Listener.Addr())
+	if rsp, err = http.ReadResponse(reader, nil); err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "a /spoof" {
+		t.Fatal("修改Host 不应该绕过connect 的地址: ", string(body))
+	}
+}
+
+func TestProxyMapRemoteInvalid(t *testing.T) {
+	for _, to := range []string{"", "://bad", "ftp://a.test"} {
+		proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+			Addr:      "127.0.0.1:0",
+			MapRemote: []proxy.MapRemote{{Match: proxy.UrlMatch{Host: "a.test"}, To: to}},
+		})
+		if err == nil {
+			proCli.Close()
+			t.Fatal("错误的映射地址应该返回错误: ", to)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gospider007/proxy"
+)
+
+func TestProxyMiddleware(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		gw.Write([]byte("hello world"))
+		gw.Close()
+	}))
+	defer server.Close()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		Middlewares: []proxy.Middleware{
+			proxy.MiddlewareFunc{
+				Request: func(req *http.Request) (*http.Response, error) {
+					switch req.URL.Path {
+					case "/mock":
+						return proxy.NewResponse(req, 200, nil, []byte("mock")), nil
+					case "/drop":
+						return nil, proxy.ErrDrop
+					}
+					return nil, nil
+				},
+				Response: func(req *http.Request, rsp *http.Response) error {
+					body, err := proxy.ReadResponseBody(rsp)
+					if err != nil {
+						return err
+					}
+					proxy.SetResponseBody(rsp, bytes.ReplaceAll(body, []byte("world"), []byte("proxy")))
+					return nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	//rawProxyGet 不会自动解压
+	statusCode, body, err := rawProxyGet(proCli.Addr(), server.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 200 {
+		t.Fatal("请求失败: ", statusCode)
+	}
+	gr, err := gzip.NewReader(bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err = buf.ReadFrom(gr); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "hello proxy" {
+		t.Fatal("修改响应失败: ", buf.String())
+	}
+	if _, body, err = rawProxyGet(proCli.Addr(), server.URL+"/mock"); err != nil || body != "mock" {
+		t.Fatal("直接返回响应失败: ", body, err)
+	}
+	if _, _, err = rawProxyGet(proCli.Addr(), server.URL+"/drop"); err == nil {
+		t.Fatal("应该断开连接")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/gospider007/proxy"
+)
+
+func TestProxyMirrorSpec(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	listener := &helloListener{Listener: server.Listener}
+	server.Listener = listener
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	//返回客户端和服务端收到的握手消息
+	mirror := func(har *proxy.HarOption) (*proxy.Session, *proxy.ClientHello, *proxy.ClientHello) {
+		sessions := make(chan *proxy.Session, 1)
+		proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+			Addr:       "127.0.0.1:0",
+			DisVerify:  true,
+			MirrorSpec: true,
+			Har:        har,
+			ClientHelloCallBack: func(session *proxy.Session, hello *proxy.ClientHello) error {
+				sessions <- session
+				return nil
+			},
+			RequestCallBack: func(r1 *http.Request, r2 *http.Response) error { return nil },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer proCli.Close()
+		go proCli.Run()
+		pool := x509.NewCertPool()
+		pool.AddCert(proCli.Ca().Certificate())
+		client := &http.Client{Transport: &http.Transport{
+			Proxy:             http.ProxyURL(&url.URL{Scheme: "http", Host: proCli.Addr()}),
+			ForceAttemptHTTP2: true, //默认的h2,http/1.1
+			TLSClientConfig: &tls.Config{
+				RootCAs:          pool,
+				CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+			},
+		}}
+		defer client.CloseIdleConnections()
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		session := <-sessions
+		listener.lock.Lock()
+		raw := listener.hellos[len(listener.hellos)-1]
+		listener.lock.Unlock()
+		serverHello, err := proxy.ParseClientHello(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return session, session.ClientHello, serverHello
+	}
+	session, clientHello, serverHello := mirror(nil)
+	if !slices.Equal(clientHello.Protocols, []string{"h2", "http/1.1"}) {
+		t.Fatal("客户端alpn 错误: ", clientHello.Protocols)
+	}
+	if serverHello.Ja3 != clientHello.Ja3 || serverHello.Ja4 != clientHello.Ja4 || !slices.Equal(serverHello.Protocols, clientHello.Protocols) {
+		t.Fatalf("服务端收到的指纹和客户端不一致:\n%s %s %v\n%s %s %v", clientHello.Ja3, clientHello.Ja4, clientHello.Protocols, serverHello.Ja3, serverHello.Ja4, serverHello.Protocols)
+	}
+	if session.MirrorHttp1 {
+		t.Fatal("alpn 没有修改")
+	}
+	//har 需要解析http1.1,alpn 改为http/1.1 并记录
+	session, clientHello, serverHello = mirror(&proxy.HarOption{Dir: t.TempDir()})
+	if !slices.Equal(serverHello.Protocols, []string{"http/1.1"}) || !session.MirrorHttp1 {
+		t.Fatal("需要解析http1.1 时alpn 错误: ", serverHello.Protocols, session.MirrorHttp1)
+	}
+	if serverHello.Ja3 != clientHello.Ja3 {
+		t.Fatal("只应该修改alpn: ", clientHello.Ja3, serverHello.Ja3)
+	}
+}
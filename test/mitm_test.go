@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gospider007/proxy"
+)
+
+func TestProxyMitm(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		Mitm: proxy.MitmOption{
+			Exclude:       []string{"*.example.com", `re:^pinned\.`},
+			FailThreshold: 2,
+		},
+		RequestCallBack: func(r1 *http.Request, r2 *http.Response) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	//返回服务端证书
+	get := func(serverName string, rootCAs *x509.CertPool) (*x509.Certificate, error) {
+		client := &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyURL(&url.URL{Scheme: "http", Host: proCli.Addr()}),
+			TLSClientConfig: &tls.Config{RootCAs: rootCAs, ServerName: serverName, InsecureSkipVerify: rootCAs == nil},
+		}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return nil, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0], nil
+	}
+	isServerCert := func(cert *x509.Certificate) bool {
+		return bytes.Equal(cert.Raw, server.Certificate().Raw)
+	}
+	caPool := x509.NewCertPool()
+	caPool.AddCert(proCli.Ca().Certificate())
+	for _, serverName := range []string{"a.example.com", "pinned.test.org"} {
+		cert, err := get(serverName, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isServerCert(cert) {
+			t.Fatal("排除的域名不应该解密: ", serverName)
+		}
+	}
+	cert, err := get("a.test.org", caPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isServerCert(cert) {
+		t.Fatal("没有中间人解密")
+	}
+	//客户端不信任根证书,连续握手失败后直接转发
+	for i := 0; i < 2; i++ {
+		if _, err = get("b.test.org", x509.NewCertPool()); err == nil {
+			t.Fatal("不信任的证书应该握手失败")
+		}
+	}
+	for i := 0; i < 30 && !slices.Contains(proCli.PassthroughHosts(), "b.test.org"); i++ {
+		time.Sleep(time.Millisecond * 100)
+	}
+	if !slices.Contains(proCli.PassthroughHosts(), "b.test.org") {
+		t.Fatal("没有自动跳过解密: ", proCli.PassthroughHosts())
+	}
+	if cert, err = get("b.test.org", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !isServerCert(cert) {
+		t.Fatal("握手失败的域名应该直接转发")
+	}
+	//重新解密
+	proCli.ResetPassthrough("b.test.org")
+	if len(proCli.PassthroughHosts()) != 0 {
+		t.Fatal("没有清除跳过解密的域名: ", proCli.PassthroughHosts())
+	}
+	if cert, err = get("b.test.org", caPool); err != nil {
+		t.Fatal(err)
+	}
+	if isServerCert(cert) {
+		t.Fatal("清除后没有重新解密")
+	}
+	//客户端发送握手后直接关闭,不算拒绝证书
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", proCli.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		host := server.Listener.Addr().String()
+		conn.Write([]byte("CONNECT " + host + " HTTP/1.1\r\nHost: " + host + "\r\n\r\n"))
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		tls.Client(&closeConn{Conn: conn}, &tls.Config{ServerName: "c.test.org", InsecureSkipVerify: true}).Handshake()
+	}
+	time.Sleep(time.Millisecond * 300)
+	if slices.Contains(proCli.PassthroughHosts(), "c.test.org") {
+		t.Fatal("关闭连接不应该跳过解密")
+	}
+}
+
+// 写完第一个包后关闭连接
+type closeConn struct {
+	net.Conn
+}
+
+func (obj *closeConn) Write(b []byte) (int, error) {
+	n, err := obj.Conn.Write(b)
+	obj.Conn.Close()
+	return n, err
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gospider007/proxy"
+	xproxy "golang.org/x/net/proxy"
+)
+
+func TestProxyPool(t *testing.T) {
+	var conns atomic.Int64
+	serverA := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("a"))
+	}))
+	serverA.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	serverA.Start()
+	defer serverA.Close()
+	serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("b"))
+	}))
+	defer serverB.Close()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	conn, err := net.Dial("tcp", proCli.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for _, server := range []struct {
+		href string
+		body string
+	}{
+		{serverA.URL, "a"},
+		{serverB.URL, "b"},
+		{serverA.URL, "a"},
+	} {
+		if _, err = fmt.Fprintf(conn, "GET %s/ HTTP/1.1\r\nHost: %s\r\n\r\n", server.href, server.href[len("http://"):]); err != nil {
+			t.Fatal(err)
+		}
+		rsp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != server.body {
+			t.Fatal("请求发送到了错误的服务端: ", server.href, string(body))
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Fatal("服务端连接没有复用: ", n)
+	}
+}
+
+// socks5 中的http 请求只发往客户端指定的目标地址,不按Host 切换连接
+func TestProxySocksDispatch(t *testing.T) {
+	var conns atomic.Int64
+	serverA := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("a"))
+	}))
+	serverA.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	serverA.Start()
+	defer serverA.Close()
+	serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("b"))
+	}))
+	defer serverB.Close()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:            "127.0.0.1:0",
+		DisVerify:       true,
+		RequestCallBack: func(r1 *http.Request, r2 *http.Response) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	dialer, err := xproxy.SOCKS5("tcp", proCli.Addr(), nil, xproxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.Dial("tcp", serverA.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for _, host := range []string{serverA.Listener.Addr().String(), serverB.Listener.Addr().String()} {
+		fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", host)
+		rsp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(rsp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "a" {
+			t.Fatal("请求没有发往socks5 的目标地址: ", host, string(body))
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Fatal("应该复用socks5 建立的连接: ", n)
+	}
+}
+
+// 同一个连接的http 代理请求按各自的地址取默认端口,目标地址改变时重新执行connect 回调
+func TestProxyForwardTarget(t *testing.T) {
+	serverA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("a"))
+	}))
+	defer serverA.Close()
+	serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("b"))
+	}))
+	defer serverB.Close()
+	hostB := serverB.Listener.Addr().String()
+	var hosts []string
+	sessionHost := make(chan string, 1)
+	logs := make(chanWriter, 10)
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		Logger:    proxy.NewJsonLogger(logs),
+		OnError: func(session *proxy.Session, stage proxy.ErrorStage, err error) {
+			sessionHost <- session.Host
+		},
+		HttpConnectCallBack: func(r *http.Request) error {
+			hosts = append(hosts, r.URL.Host)
+			if r.URL.Host == "127.0.0.2:80" {
+				return errors.New("forbidden")
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	conn, err := net.Dial("tcp", proCli.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for _, href := range []string{serverA.URL + "/", serverA.URL + "/x", serverB.URL + "/"} {
+		if _, err = fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\n\r\n", href, href[len("http://"):]); err != nil {
+			t.Fatal(err)
+		}
+		rsp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, rsp.Body)
+		rsp.Body.Close()
+	}
+	if len(hosts) != 2 || hosts[1] != hostB {
+		t.Fatal("目标地址改变时没有执行connect 回调: ", hosts)
+	}
+	//没有端口时按http 默认端口80,不使用上一个请求的端口
+	fmt.Fprintf(conn, "GET http://127.0.0.2/ HTTP/1.1\r\nHost: 127.0.0.2\r\n\r\n")
+	if rsp, err := http.ReadResponse(reader, nil); err == nil {
+		t.Fatal("connect 回调拒绝的请求不应该转发: ", rsp.StatusCode)
+	}
+	if hosts[len(hosts)-1] != "127.0.0.2:80" {
+		t.Fatal("默认端口错误: ", hosts)
+	}
+	select {
+	case host := <-sessionHost:
+		if host != "127.0.0.2:80" {
+			t.Fatal("会话没有更新目标地址: ", host)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("没有错误回调")
+	}
+	for {
+		select {
+		case content := <-logs:
+			var accessLog proxy.AccessLog
+			if err = json.Unmarshal(content, &accessLog); err != nil {
+				t.Fatal(err)
+			}
+			if accessLog.Error == "" {
+				continue
+			}
+			if accessLog.Host != "127.0.0.2:80" {
+				t.Fatal("拒绝的请求日志错误: ", string(content))
+			}
+			return
+		case <-time.After(time.Second * 3):
+			t.Fatal("拒绝的请求没有日志")
+		}
+	}
+}
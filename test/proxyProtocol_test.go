@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gospider007/proxy"
+	"github.com/gospider007/requests"
+)
+
+// 读取PROXY protocol 头后返回头部内容
+func proxyProtocolServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				req, err := http.ReadRequest(reader)
+				if err != nil {
+					return
+				}
+				body, _ := json.Marshal(map[string]string{"header": strings.TrimSpace(line), "host": req.Host})
+				rsp := http.Response{
+					StatusCode:    200,
+					ProtoMajor:    1,
+					ProtoMinor:    1,
+					Header:        http.Header{"Content-Type": []string{"application/json"}},
+					ContentLength: int64(len(body)),
+					Body:          io.NopCloser(bytes.NewReader(body)),
+				}
+				rsp.Write(conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestProxyProtocol(t *testing.T) {
+	listener := proxyProtocolServer(t)
+	defer listener.Close()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:          "127.0.0.1:0",
+		DisVerify:     true,
+		ProxyProtocol: proxy.ProxyProtocolV1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{Proxy: "http://" + proCli.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := reqCli.Request(nil, "get", "http://"+listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonData, _ := resp.Json()
+	if !strings.HasPrefix(jsonData.Get("header").String(), "PROXY TCP4 127.0.0.1 ") {
+		t.Fatal("proxy protocol 头错误: ", resp.Text())
+	}
+}
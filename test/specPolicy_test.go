@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gospider007/proxy"
+)
+
+func TestProxySpecRules(t *testing.T) {
+	if !slices.Contains(proxy.BuiltinTlsSpecNames(), "chrome") {
+		t.Fatal("没有内置chrome 指纹")
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	listener := &helloListener{Listener: server.Listener}
+	server.Listener = listener
+	server.StartTLS()
+	defer server.Close()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		SpecRules: []proxy.SpecRule{{
+			Host:  "*.TEST.ORG", //不区分大小写
+			Specs: []string{"firefox"},
+		}, {
+			Host:     "127.0.0.1",
+			Specs:    []string{"chrome", "firefox"},
+			Strategy: proxy.SpecRoundRobin,
+		}},
+		RequestCallBack: func(r1 *http.Request, r2 *http.Response) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	pool := x509.NewCertPool()
+	pool.AddCert(proCli.Ca().Certificate())
+	var ja4s []string
+	for _, name := range []string{"chrome", "firefox"} {
+		spec, err := proxy.BuiltinTlsSpec(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hello, err := proxy.ParseClientHello(spec.TLSSpec.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hello.ServerName != "" || !strings.Contains(hello.Ja4, "d") {
+			t.Fatal("内置指纹应该有sni 扩展,不包含域名: ", hello.ServerName)
+		}
+		// 域名与alpn 由实际连接决定，只比较套件与扩展的hash
+		ja4s = append(ja4s, hello.Ja4[strings.Index(hello.Ja4, "_"):])
+	}
+	href, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, host := range []string{"127.0.0.1", "127.0.0.1", "localhost", "127.0.0.1"} {
+		tlsConfig := &tls.Config{RootCAs: pool}
+		proxyUrl := &url.URL{Scheme: "http", Host: proCli.Addr()}
+		if i == 3 { //socks5 按sni 匹配第一个规则
+			tlsConfig.ServerName = "a.test.org"
+			proxyUrl.Scheme = "socks5"
+		}
+		client := &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyUrl),
+			TLSClientConfig: tlsConfig,
+		}}
+		resp, err := client.Get("https://" + net.JoinHostPort(host, href.Port()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	listener.lock.Lock()
+	hellos := listener.hellos
+	listener.lock.Unlock()
+	if len(hellos) != 4 {
+		t.Fatal("握手次数错误: ", len(hellos))
+	}
+	for i, raw := range hellos {
+		hello, err := proxy.ParseClientHello(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ja4 := hello.Ja4[strings.Index(hello.Ja4, "_"):]
+		if i < 2 && ja4 != ja4s[i] {
+			t.Fatalf("第%d 个连接指纹错误: %s %s", i, ja4, ja4s[i])
+		}
+		if i == 2 && slices.Contains(ja4s, ja4) {
+			t.Fatal("不匹配的域名不应该使用规则的指纹")
+		}
+		if i == 3 && ja4 != ja4s[1] {
+			t.Fatal("大写的规则没有匹配: ", ja4)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gospider007/proxy"
+)
+
+func TestProxyStreamCallBack(t *testing.T) {
+	next := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("event: ping\ndata: 1\n\ndata: hello\ndata: token\n\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-next:
+		case <-time.After(time.Second * 3):
+		}
+		w.Write([]byte(": keepalive\n\nid: 2\ndata: world\n\n"))
+	}))
+	defer server.Close()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		StreamCallBack: func(event *proxy.StreamEvent) error {
+			if event.Event == "ping" {
+				return proxy.ErrEventDrop
+			}
+			if strings.HasPrefix(string(event.Raw()), ":") {
+				t.Error("只有注释的块不应该回调")
+			}
+			event.Data = strings.ToUpper(event.Data)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	conn, err := net.Dial("tcp", proCli.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET %s/sse HTTP/1.1\r\nConnection: close\r\n\r\n", server.URL)
+	rsp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	reader := bufio.NewReader(rsp.Body)
+	readEvent := func() string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\n" && len(lines) > 0 {
+				return strings.Join(lines, "")
+			}
+			if line != "\n" && !strings.HasPrefix(line, ":") { //跳过注释
+				lines = append(lines, line)
+			}
+		}
+	}
+	if event := readEvent(); event != "data: HELLO\ndata: TOKEN\n" { //流没有结束时收到第一个事件
+		t.Fatal("事件错误: ", event)
+	}
+	close(next)
+	if event := readEvent(); event != "id: 2\ndata: WORLD\n" {
+		t.Fatal("事件错误: ", event)
+	}
+}
+
+// 超长的事件不解析,原样转发
+func TestProxyStreamTooLong(t *testing.T) {
+	content := "data: " + strings.Repeat("a", 2<<20) + "\n\ndata: b\n\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		StreamCallBack: func(event *proxy.StreamEvent) error {
+			event.Data = strings.ToUpper(event.Data)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	statusCode, body, err := rawProxyGet(proCli.Addr(), server.URL+"/sse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 200 || body != content {
+		t.Fatal("超长事件没有原样转发: ", statusCode, len(body), len(content))
+	}
+}
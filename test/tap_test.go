@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/gospider007/proxy"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+func TestProxyBodyTap(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer server.Close()
+	var lock sync.Mutex
+	var size int64
+	var done bool
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		BodyTap: func(meta *proxy.BodyMeta, chunk []byte, direction proxy.BodyDirection) {
+			if direction != proxy.BodyResponse {
+				return
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if meta.Offset != size {
+				t.Error("偏移错误: ", meta.Offset, size)
+			}
+			size += int64(len(chunk))
+			if meta.Done {
+				done = meta.StatusCode == 200 && meta.Url == server.URL+"/big"
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	statusCode, body, err := rawProxyGet(proCli.Addr(), server.URL+"/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 200 || len(body) != len(content) {
+		t.Fatal("请求失败: ", statusCode, len(body))
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if size != int64(len(content)) || !done {
+		t.Fatal("body 回调错误: ", size, done)
+	}
+}
+
+// 中间人解密的h2 连接按帧回调body
+func TestProxyBodyTapH2(t *testing.T) {
+	reqContent := bytes.Repeat([]byte("request "), 10000)
+	rspContent := bytes.Repeat([]byte("response "), 20000)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write(rspContent)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	var lock sync.Mutex
+	bodys := map[proxy.BodyDirection]*bytes.Buffer{proxy.BodyRequest: {}, proxy.BodyResponse: {}}
+	metas := map[proxy.BodyDirection]proxy.BodyMeta{}
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		BodyTap: func(meta *proxy.BodyMeta, chunk []byte, direction proxy.BodyDirection) {
+			lock.Lock()
+			defer lock.Unlock()
+			bodys[direction].Write(chunk)
+			if meta.Done {
+				metas[direction] = *meta
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	pool := x509.NewCertPool()
+	pool.AddCert(proCli.Ca().Certificate())
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:             http.ProxyURL(&url.URL{Scheme: "http", Host: proCli.Addr()}),
+		TLSClientConfig:   &tls.Config{RootCAs: pool},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Post(server.URL+"/h2", "text/plain", bytes.NewReader(reqContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Proto != "HTTP/2.0" || !bytes.Equal(body, rspContent) {
+		t.Fatal("请求失败: ", resp.Proto, len(body))
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if !bytes.Equal(bodys[proxy.BodyRequest].Bytes(), reqContent) || !bytes.Equal(bodys[proxy.BodyResponse].Bytes(), rspContent) {
+		t.Fatal("body 回调错误: ", bodys[proxy.BodyRequest].Len(), bodys[proxy.BodyResponse].Len())
+	}
+	reqMeta, rspMeta := metas[proxy.BodyRequest], metas[proxy.BodyResponse]
+	if reqMeta.StreamId == 0 || reqMeta.Method != http.MethodPost || reqMeta.Url != server.URL+"/h2" {
+		t.Fatal("请求信息错误: ", reqMeta.StreamId, reqMeta.Method, reqMeta.Url)
+	}
+	if rspMeta.StreamId != reqMeta.StreamId || rspMeta.StatusCode != 200 || rspMeta.Url != reqMeta.Url {
+		t.Fatal("响应信息错误: ", rspMeta.StreamId, rspMeta.StatusCode, rspMeta.Url)
+	}
+}
+
+// 响应先结束,请求一直没有结束的流不能占满记录
+func TestProxyBodyTapH2Streams(t *testing.T) {
+	testServer := httptest.NewUnstartedServer(nil)
+	testServer.StartTLS()
+	cert := testServer.TLS.Certificates[0]
+	testServer.Close()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() { //收到请求头就返回完整响应,不等请求结束
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err = io.ReadFull(conn, make([]byte, len(http2.ClientPreface))); err != nil {
+			return
+		}
+		framer := http2.NewFramer(conn, conn)
+		framer.WriteSettings()
+		var block bytes.Buffer
+		encoder := hpack.NewEncoder(&block)
+		for {
+			frame, err := framer.ReadFrame()
+			if err != nil {
+				return
+			}
+			switch frame := frame.(type) {
+			case *http2.SettingsFrame:
+				if !frame.IsAck() {
+					framer.WriteSettingsAck()
+				}
+			case *http2.HeadersFrame:
+				block.Reset()
+				encoder.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+				framer.WriteHeaders(http2.HeadersFrameParam{StreamID: frame.StreamID, BlockFragment: block.Bytes(), EndHeaders: true})
+				framer.WriteData(frame.StreamID, true, []byte("ok"))
+			}
+		}
+	}()
+	var lock sync.Mutex
+	var done int
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		BodyTap: func(meta *proxy.BodyMeta, chunk []byte, direction proxy.BodyDirection) {
+			if direction == proxy.BodyResponse && meta.Done {
+				lock.Lock()
+				done++
+				lock.Unlock()
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	conn, err := net.Dial("tcp", proCli.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	target := listener.Addr().String()
+	if _, err = conn.Write([]byte("CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatal("connect 失败: ", resp.StatusCode)
+	}
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}})
+	if err = tlsConn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if tlsConn.ConnectionState().NegotiatedProtocol != "h2" {
+		t.Fatal("没有协商h2")
+	}
+	if _, err = tlsConn.Write([]byte(http2.ClientPreface)); err != nil {
+		t.Fatal(err)
+	}
+	framer := http2.NewFramer(tlsConn, tlsConn)
+	framer.WriteSettings()
+	var block bytes.Buffer
+	encoder := hpack.NewEncoder(&block)
+	streams := 1100
+	for i := range streams {
+		streamId := uint32(i*2 + 1)
+		block.Reset()
+		encoder.WriteField(hpack.HeaderField{Name: ":method", Value: http.MethodPost})
+		encoder.WriteField(hpack.HeaderField{Name: ":scheme", Value: "https"})
+		encoder.WriteField(hpack.HeaderField{Name: ":authority", Value: target})
+		encoder.WriteField(hpack.HeaderField{Name: ":path", Value: "/"})
+		if err = framer.WriteHeaders(http2.HeadersFrameParam{StreamID: streamId, BlockFragment: block.Bytes(), EndHeaders: true}); err != nil {
+			t.Fatal(err)
+		}
+		if err = framer.WriteData(streamId, false, []byte("x")); err != nil { //请求不结束
+			t.Fatal(err)
+		}
+		for {
+			frame, err := framer.ReadFrame()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if settings, ok := frame.(*http2.SettingsFrame); ok && !settings.IsAck() {
+				framer.WriteSettingsAck()
+			}
+			if data, ok := frame.(*http2.DataFrame); ok && data.StreamID == streamId && data.StreamEnded() {
+				break
+			}
+		}
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if done != streams {
+		t.Fatal("超过记录数量后没有回调: ", done, streams)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gospider007/proxy"
+	"github.com/gospider007/tools"
+)
+
+func TestProxyTrafficQuota(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("a", 1024)))
+	}))
+	defer server.Close()
+	storePath := filepath.Join(t.TempDir(), "traffic.json")
+	store, err := proxy.NewFileTrafficStore(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:         "127.0.0.1:0",
+		Usr:          "admin",
+		Pwd:          "password",
+		TrafficStore: store,
+		TrafficQuota: 1024,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	auth := "Proxy-Authorization: Basic " + tools.Base64Encode("admin:password") + "\r\n"
+	statusCode, _, err := rawProxyGet(proCli.Addr(), server.URL, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 200 {
+		t.Fatal("第一个请求应该成功: ", statusCode)
+	}
+	statusCode, _, err = rawProxyGet(proCli.Addr(), server.URL, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 403 {
+		t.Fatal("流量用完后应该拒绝: ", statusCode)
+	}
+	if err = store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	store2, err := proxy.NewFileTrafficStore(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used, _ := store2.Get("admin"); used < 1024 {
+		t.Fatal("流量统计没有保存: ", used)
+	}
+}
+
+func TestProxyTrafficUpload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer server.Close()
+	store := proxy.NewMemoryTrafficStore()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:         "127.0.0.1:0",
+		Usr:          "admin",
+		Pwd:          "password",
+		TrafficStore: store,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	conn, err := net.Dial("tcp", proCli.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	content := bytes.Repeat([]byte("a"), 100000)
+	go fmt.Fprintf(conn, "POST %s HTTP/1.1\r\nHost: %s\r\nProxy-Authorization: Basic %s\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		server.URL, server.Listener.Addr(), tools.Base64Encode("admin:password"), len(content), content)
+	rsp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, rsp.Body)
+	if used, _ := store.Get("admin"); used < int64(len(content)) {
+		t.Fatal("上传的body 没有计入流量: ", used)
+	}
+}
+
+// 没有验证的用户名不计入流量
+func TestProxyTrafficUnverified(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	store := proxy.NewMemoryTrafficStore()
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:         "127.0.0.1:0",
+		DisVerify:    true,
+		TrafficStore: store,
+		TrafficQuota: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	for range 2 {
+		statusCode, _, err := rawProxyGet(proCli.Addr(), server.URL, "Proxy-Authorization: Basic "+tools.Base64Encode("bob:x")+"\r\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if statusCode != 200 {
+			t.Fatal("没有认证用户不应该限制流量: ", statusCode)
+		}
+	}
+	if used, _ := store.Get("bob"); used != 0 {
+		t.Fatal("没有验证的用户名不应该计入流量: ", used)
+	}
+}
+
+// socks5 udp 的流量计入会话和流量统计
+func TestProxyTrafficUdp(t *testing.T) {
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], addr)
+		}
+	}()
+	store := proxy.NewMemoryTrafficStore()
+	logs := make(chanWriter, 10)
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:         "127.0.0.1:0",
+		Usr:          "admin",
+		Pwd:          "password",
+		TrafficStore: store,
+		Logger:       proxy.NewJsonLogger(logs),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	conn, err := net.Dial("tcp", proCli.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte{5, 1, 2})
+	conn.Write(append(append([]byte{1, 5}, "admin"...), append([]byte{8}, "password"...)...))
+	conn.Write([]byte{5, 3, 0, 1, 0, 0, 0, 0, 0, 0}) //udp associate
+	reply := make([]byte, 2+2+10)
+	if _, err = io.ReadFull(reader, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply[3] != 0 || reply[5] != 0 {
+		t.Fatal("udp associate 失败: ", reply)
+	}
+	relay, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IP(reply[8:12]), Port: int(reply[12])<<8 | int(reply[13])})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+	port := echo.LocalAddr().(*net.UDPAddr).Port
+	payload := bytes.Repeat([]byte("a"), 1000)
+	relay.Write(append([]byte{0, 0, 0, 1, 127, 0, 0, 1, byte(port >> 8), byte(port)}, payload...))
+	relay.SetReadDeadline(time.Now().Add(time.Second * 3))
+	buf := make([]byte, 2048)
+	n, err := relay.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10+len(payload) {
+		t.Fatal("udp 回复错误: ", n)
+	}
+	conn.Close()
+	select {
+	case content := <-logs:
+		var accessLog proxy.AccessLog
+		if err = json.Unmarshal(content, &accessLog); err != nil {
+			t.Fatal(err)
+		}
+		if accessLog.UpBytes < int64(len(payload)) || accessLog.DownBytes < int64(len(payload)) {
+			t.Fatal("udp 流量没有计入会话: ", string(content))
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("没有访问日志")
+	}
+	if used, _ := store.Get("admin"); used < int64(len(payload)*2) {
+		t.Fatal("udp 流量没有计入统计: ", used)
+	}
+}
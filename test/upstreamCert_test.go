@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gospider007/proxy"
+)
+
+func TestProxyUpstreamCert(t *testing.T) {
+	ca, cert := createClientCert(t, "crawler")
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{ClientCAs: roots, ClientAuth: tls.RequireAndVerifyClientCert}
+	server.StartTLS()
+	defer server.Close()
+	get := func(host string) (string, error) {
+		proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+			Addr:            "127.0.0.1:0",
+			DisVerify:       true,
+			UpstreamCerts:   []proxy.UpstreamCert{{Host: host, Cert: cert}},
+			RequestCallBack: func(r1 *http.Request, r2 *http.Response) error { return nil },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer proCli.Close()
+		go proCli.Run()
+		pool := x509.NewCertPool()
+		pool.AddCert(proCli.Ca().Certificate())
+		client := &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyURL(&url.URL{Scheme: "http", Host: proCli.Addr()}),
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		content, err := io.ReadAll(resp.Body)
+		return string(content), err
+	}
+	content, err := get("127.0.0.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content != "crawler" {
+		t.Fatal("没有使用客户端证书: ", content)
+	}
+	if _, err = get("*.example.com"); err == nil {
+		t.Fatal("不匹配的地址不应该使用客户端证书")
+	}
+}
+
+// https 上游代理要求客户端证书
+func TestProxyUpstreamProxyCert(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	ca, cert := createClientCert(t, "crawler")
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	logs := make(chanWriter, 10)
+	upstream, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:       "127.0.0.1:0",
+		ClientCert: &proxy.ClientCertOption{Roots: roots},
+		Logger:     proxy.NewJsonLogger(logs),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	go upstream.Run()
+	get := func(host string) (int, error) {
+		proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+			Addr:          "127.0.0.1:0",
+			DisVerify:     true,
+			Proxy:         "https://" + upstream.Addr(),
+			UpstreamCerts: []proxy.UpstreamCert{{Host: host, Cert: cert}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer proCli.Close()
+		go proCli.Run()
+		client := &http.Client{Transport: &http.Transport{
+			Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: proCli.Addr()}),
+		}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return 0, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+	statusCode, err := get("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 200 {
+		t.Fatal("上游代理客户端证书认证失败: ", statusCode)
+	}
+	select {
+	case content := <-logs:
+		var accessLog proxy.AccessLog
+		if err = json.Unmarshal(content, &accessLog); err != nil {
+			t.Fatal(err)
+		}
+		if accessLog.User != "crawler" {
+			t.Fatal("上游代理证书用户错误: ", string(content))
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("上游代理没有访问日志")
+	}
+	if statusCode, err = get("*.example.com"); err == nil && statusCode == 200 {
+		t.Fatal("不匹配的上游代理不应该使用客户端证书")
+	}
+}
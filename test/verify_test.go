@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gospider007/proxy"
+)
+
+func TestProxyVerify(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	events := make(chan *proxy.VerifyEvent, 10)
+	get := func(verify proxy.VerifyOption, tlsConfig *tls.Config) (*http.Response, error) {
+		proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+			Addr:            "127.0.0.1:0",
+			DisVerify:       true,
+			Verify:          verify,
+			RequestCallBack: func(r1 *http.Request, r2 *http.Response) error { return nil },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer proCli.Close()
+		go proCli.Run()
+		if tlsConfig.RootCAs != nil {
+			tlsConfig.RootCAs.AddCert(proCli.Ca().Certificate())
+		}
+		client := &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyURL(&url.URL{Scheme: "http", Host: proCli.Addr()}),
+			TLSClientConfig: tlsConfig,
+		}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return nil, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp, nil
+	}
+	//公钥固定
+	resp, err := get(proxy.VerifyOption{
+		Mode: proxy.VerifyPin,
+		Pins: []string{"sha256/" + proxy.SpkiHash(server.Certificate())},
+	}, &tls.Config{RootCAs: x509.NewCertPool()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatal("请求失败: ", resp.StatusCode)
+	}
+	//验证失败返回无效证书
+	resp, err = get(proxy.VerifyOption{
+		Mode:   proxy.VerifySystem,
+		OnFail: func(event *proxy.VerifyEvent) { events <- event },
+	}, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf := resp.TLS.PeerCertificates[0]; len(leaf.Subject.Organization) == 0 || leaf.Subject.Organization[0] != "UNTRUSTED UPSTREAM CERTIFICATE" {
+		t.Fatal("没有返回无效证书: ", leaf.Subject)
+	}
+	select {
+	case event := <-events:
+		if event.Err == nil || len(event.Certificates) == 0 || event.Session == nil {
+			t.Fatal("验证失败事件错误")
+		}
+	default:
+		t.Fatal("没有验证失败事件")
+	}
+	//验证失败拒绝连接
+	if _, err = get(proxy.VerifyOption{
+		Mode:   proxy.VerifyRoots,
+		Roots:  x509.NewCertPool(),
+		Reject: true,
+	}, &tls.Config{InsecureSkipVerify: true}); err == nil {
+		t.Fatal("验证失败应该拒绝连接")
+	}
+	//匹配map 规则的隧道先和客户端握手,验证失败返回502
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		Verify:    proxy.VerifyOption{Mode: proxy.VerifySystem},
+		MapRemote: []proxy.MapRemote{{Match: proxy.UrlMatch{Host: "127.0.0.1"}, To: server.URL}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(&url.URL{Scheme: "http", Host: proCli.Addr()}),
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	resp, err = client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatal("验证失败应该返回502: ", resp.StatusCode)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gospider007/proxy"
+	"github.com/gospider007/websocket"
+)
+
+type bufConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (obj *bufConn) Read(p []byte) (int, error) {
+	return obj.reader.Read(p)
+}
+
+func wsAccept(key string) string {
+	hash := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func TestProxyWsHandler(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", wsAccept(r.Header.Get("Sec-WebSocket-Key")))
+		rw.Flush()
+		wsConn := websocket.NewConn(&bufConn{Conn: conn, reader: rw.Reader}, false, "")
+		for {
+			msgType, data, err := wsConn.ReadMessage()
+			if err != nil || msgType == websocket.CloseMessage {
+				return
+			}
+			if err = wsConn.WriteMessage(msgType, data); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+	var upgradeUrl string
+	proCli, err := proxy.NewClient(nil, proxy.ClientOption{
+		Addr:      "127.0.0.1:0",
+		DisVerify: true,
+		WsHandler: func(conn *proxy.WsConn) proxy.WsMessageHandler {
+			upgradeUrl = conn.Request.URL.String()
+			return func(wsType proxy.WsType, msgType websocket.MessageType, data []byte) ([]byte, error) {
+				if wsType != proxy.WsSend {
+					return data, nil
+				}
+				switch string(data) {
+				case "hello":
+					return []byte("HELLO"), nil
+				case "inject":
+					conn.WriteToClient(websocket.TextMessage, []byte("injected"))
+					return nil, proxy.ErrWsDrop
+				case "close":
+					conn.Close(4000, "bye")
+					return nil, proxy.ErrWsDrop
+				}
+				return data, nil
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proCli.Close()
+	go proCli.Run()
+	conn, err := net.Dial("tcp", proCli.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	fmt.Fprintf(conn, "GET %s/ws HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", server.URL, server.Listener.Addr(), key)
+	reader := bufio.NewReader(conn)
+	rsp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.StatusCode != 101 || rsp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		t.Fatal("websocket 握手失败: ", rsp.Status)
+	}
+	wsConn := websocket.NewConn(&bufConn{Conn: conn, reader: reader}, true, "")
+	for _, msg := range []struct {
+		send string
+		recv string
+	}{
+		{"hello", "HELLO"},
+		{"inject", "injected"},
+		{"world", "world"},
+	} {
+		if err = wsConn.WriteMessage(websocket.TextMessage, []byte(msg.send)); err != nil {
+			t.Fatal(err)
+		}
+		_, data, err := wsConn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != msg.recv {
+			t.Fatal("消息错误: ", msg.send, string(data))
+		}
+	}
+	if upgradeUrl != server.URL+"/ws" {
+		t.Fatal("升级请求错误: ", upgradeUrl)
+	}
+	if err = wsConn.WriteMessage(websocket.TextMessage, []byte("close")); err != nil {
+		t.Fatal(err)
+	}
+	msgType, data, err := wsConn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgType != websocket.CloseMessage || len(data) < 2 || binary.BigEndian.Uint16(data) != 4000 || string(data[2:]) != "bye" {
+		t.Fatal("关闭消息错误: ", msgType, data)
+	}
+}
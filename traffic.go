@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errTrafficQuota = errors.New("traffic quota exhausted")
+
+// 流量统计存储,按用户累计字节数
+type TrafficStore interface {
+	Add(usr string, n int64) error
+	Get(usr string) (int64, error)
+}
+
+// 内存流量统计,重启后清零
+type MemoryTrafficStore struct {
+	lock sync.Mutex
+	data map[string]int64
+}
+
+func NewMemoryTrafficStore() *MemoryTrafficStore {
+	return &MemoryTrafficStore{data: make(map[string]int64)}
+}
+func (obj *MemoryTrafficStore) Add(usr string, n int64) error {
+	obj.lock.Lock()
+	obj.data[usr] += n
+	obj.lock.Unlock()
+	return nil
+}
+func (obj *MemoryTrafficStore) Get(usr string) (int64, error) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	return obj.data[usr], nil
+}
+
+// 所有用户的流量
+func (obj *MemoryTrafficStore) All() map[string]int64 {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	data := make(map[string]int64, len(obj.data))
+	for usr, n := range obj.data {
+		data[usr] = n
+	}
+	return data
+}
+
+// json 文件流量统计,启动时加载,后台每秒保存一次变化,退出前调用Close 停止保存并写入最后的计数
+type FileTrafficStore struct {
+	MemoryTrafficStore
+	path      string
+	saveLock  sync.Mutex
+	dirty     atomic.Bool
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+func NewFileTrafficStore(path string) (*FileTrafficStore, error) {
+	store := &FileTrafficStore{
+		MemoryTrafficStore: MemoryTrafficStore{data: make(map[string]int64)},
+		path:               path,
+		closeCh:            make(chan struct{}),
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else if len(content) > 0 {
+		if err = json.Unmarshal(content, &store.data); err != nil {
+			return nil, err
+		}
+	}
+	go store.run()
+	return store, nil
+}
+
+// 后台保存,不阻塞转发
+func (obj *FileTrafficStore) run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-obj.closeCh:
+			return
+		case <-ticker.C:
+			if obj.dirty.Swap(false) && obj.Save() != nil {
+				obj.dirty.Store(true) //下次重试
+			}
+		}
+	}
+}
+func (obj *FileTrafficStore) Add(usr string, n int64) error {
+	obj.MemoryTrafficStore.Add(usr, n)
+	obj.dirty.Store(true)
+	return nil
+}
+
+// 写入文件
+func (obj *FileTrafficStore) Save() error {
+	obj.saveLock.Lock()
+	defer obj.saveLock.Unlock()
+	return obj.save()
+}
+func (obj *FileTrafficStore) save() error {
+	content, err := json.Marshal(obj.All())
+	if err != nil {
+		return err
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(obj.path), filepath.Base(obj.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err = tempFile.Write(content); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err = tempFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), obj.path)
+}
+func (obj *FileTrafficStore) Close() error {
+	obj.closeOnce.Do(func() { close(obj.closeCh) })
+	return obj.Save()
+}
+
+// 客户端连接的流量计数
+type trafficCounter struct {
+	store TrafficStore
+	usr   string
+}
+
+func (obj *trafficCounter) add(n int) {
+	if obj != nil && n > 0 {
+		obj.store.Add(obj.usr, int64(n))
+	}
+}
@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"strings"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// 连接服务端或https 上游代理时使用的客户端证书
+type UpstreamCert struct {
+	Host string //匹配的地址,支持通配符*.example.com,为空时匹配所有,上游代理按代理地址匹配
+	Cert tls.Certificate
+}
+
+// 返回地址匹配的第一个客户端证书
+func (obj *Client) upstreamCert(addr string) *tls.Certificate {
+	host := mitmHost(addr)
+	for i := range obj.upstreamCerts {
+		if globMatch(strings.ToLower(obj.upstreamCerts[i].Host), host) {
+			return &obj.upstreamCerts[i].Cert
+		}
+	}
+	return nil
+}
+
+func utlsCertificate(cert *tls.Certificate) utls.Certificate {
+	algorithms := make([]utls.SignatureScheme, len(cert.SupportedSignatureAlgorithms))
+	for i, algorithm := range cert.SupportedSignatureAlgorithms {
+		algorithms[i] = utls.SignatureScheme(algorithm)
+	}
+	return utls.Certificate{
+		Certificate:                  cert.Certificate,
+		PrivateKey:                   cert.PrivateKey,
+		SupportedSignatureAlgorithms: algorithms,
+		OCSPStaple:                   cert.OCSPStaple,
+		SignedCertificateTimestamps:  cert.SignedCertificateTimestamps,
+		Leaf:                         cert.Leaf,
+	}
+}
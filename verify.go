@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 服务端证书验证方式
+type VerifyMode int
+
+const (
+	VerifySkip   VerifyMode = iota //不验证,默认
+	VerifySystem                   //使用系统根证书验证
+	VerifyRoots                    //使用自定义根证书验证
+	VerifyPin                      //证书链中有公钥的sha256 在Pins 中
+)
+
+// 中间人连接服务端时的证书验证配置
+type VerifyOption struct {
+	Mode  VerifyMode
+	Roots *x509.CertPool //VerifyRoots 的根证书
+	Pins  []string       //VerifyPin 的公钥(SPKI)sha256,base64 编码,可以带sha256/ 前缀
+	//验证失败时拒绝连接,否则中间人解密时向客户端返回无效的证书,让客户端看到错误,客户端不使用tls 时总是拒绝
+	//按请求连接服务端时(匹配map 规则或回放的隧道,http 代理的https 请求)客户端已经完成握手,验证失败时返回502
+	Reject bool
+	//验证失败回调
+	OnFail func(*VerifyEvent)
+}
+
+// 服务端证书验证失败事件
+type VerifyEvent struct {
+	Session      *Session
+	ServerName   string
+	Certificates []*x509.Certificate //服务端返回的证书链
+	Err          error
+}
+
+// 服务端证书验证失败
+type VerifyError struct {
+	ServerName string
+	Err        error
+}
+
+func (obj *VerifyError) Error() string {
+	return fmt.Sprintf("verify %s certificate: %v", obj.ServerName, obj.Err)
+}
+func (obj *VerifyError) Unwrap() error {
+	return obj.Err
+}
+
+// 公钥的sha256,base64 编码
+func SpkiHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func (obj *VerifyOption) verify(serverName string, certs []*x509.Certificate) error {
+	if len(certs) == 0 {
+		return errors.New("no certificate")
+	}
+	switch obj.Mode {
+	case VerifySystem, VerifyRoots:
+		opts := x509.VerifyOptions{
+			DNSName:       serverName,
+			Intermediates: x509.NewCertPool(),
+		}
+		if obj.Mode == VerifyRoots {
+			if obj.Roots == nil {
+				return errors.New("no roots")
+			}
+			opts.Roots = obj.Roots
+		}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(opts)
+		return err
+	case VerifyPin:
+		for _, cert := range certs {
+			hash := SpkiHash(cert)
+			if slices.ContainsFunc(obj.Pins, func(pin string) bool {
+				return strings.TrimPrefix(pin, "sha256/") == hash
+			}) {
+				return nil
+			}
+		}
+		return errors.New("certificate pin mismatch")
+	default:
+		return nil
+	}
+}
+
+// 验证服务端证书,失败时回调并返回VerifyError
+func (obj *Client) verifyServer(clientOption *ProxyOption, serverName string, certs []*x509.Certificate) error {
+	err := obj.verify.verify(serverName, certs)
+	if err == nil {
+		return nil
+	}
+	if obj.verify.OnFail != nil {
+		obj.verify.OnFail(&VerifyEvent{
+			Session:      clientOption.session,
+			ServerName:   serverName,
+			Certificates: certs,
+			Err:          err,
+		})
+	}
+	return withStage(StageVerify, &VerifyError{ServerName: serverName, Err: err})
+}
+
+// 签发客户端不会信任的自签名证书,让客户端看到服务端证书的错误
+func untrustedCert(serverName string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:         serverName,
+			Organization:       []string{"UNTRUSTED UPSTREAM CERTIFICATE"},
+			OrganizationalUnit: []string{"proxy failed to verify the server certificate"},
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(0, 0, 1),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	if ip := net.ParseIP(serverName); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else if serverName != "" {
+		template.DNSNames = []string{serverName}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// 是否是服务端证书验证错误,并且不拒绝连接
+func (obj *Client) isUntrusted(err error) bool {
+	var verifyErr *VerifyError
+	return !obj.verify.Reject && errors.As(err, &verifyErr)
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/gospider007/websocket"
+)
+
+// ws 消息处理返回ErrWsDrop 时丢弃这条消息
+var ErrWsDrop = errors.New("websocket message dropped")
+
+// 处理一个websocket 连接的消息,返回替换的消息内容,返回ErrWsDrop 丢弃消息,返回其他错误关闭连接
+type WsMessageHandler func(wsType WsType, msgType websocket.MessageType, data []byte) ([]byte, error)
+
+// 加锁写消息,转发和主动发送可能同时写
+type wsWriter struct {
+	lock sync.Mutex
+	conn *websocket.Conn
+}
+
+func (obj *wsWriter) write(msgType websocket.MessageType, data []byte) error {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	return obj.conn.WriteMessage(msgType, data)
+}
+
+// websocket 连接,可以在任意时候向客户端或服务端发送消息
+type WsConn struct {
+	Request   *http.Request //升级请求
+	Session   *Session
+	client    *wsWriter //写给客户端
+	server    *wsWriter //写给服务端
+	closeOnce sync.Once
+}
+
+// 发送消息给客户端
+func (obj *WsConn) WriteToClient(msgType websocket.MessageType, data []byte) error {
+	return obj.client.write(msgType, data)
+}
+
+// 发送消息给服务端
+func (obj *WsConn) WriteToServer(msgType websocket.MessageType, data []byte) error {
+	return obj.server.write(msgType, data)
+}
+
+// 用指定的关闭码向两端发送关闭消息,然后断开连接
+func (obj *WsConn) Close(code int, reason string) error {
+	var err error
+	obj.closeOnce.Do(func() {
+		payload := make([]byte, 2, 2+len(reason))
+		binary.BigEndian.PutUint16(payload, uint16(code))
+		payload = append(payload, reason...)
+		err = obj.client.write(websocket.CloseMessage, payload)
+		if serverErr := obj.server.write(websocket.CloseMessage, payload); err == nil {
+			err = serverErr
+		}
+		obj.client.conn.Close()
+		obj.server.conn.Close()
+	})
+	return err
+}
+
+// 转发一个方向的消息
+func (obj *WsConn) copy(handler WsMessageHandler, wsType WsType, reader *websocket.Conn, writer *wsWriter) (err error) {
+	defer reader.Close()
+	defer writer.conn.Close()
+	var msgType websocket.MessageType
+	var msgData []byte
+	for {
+		if msgType, msgData, err = reader.ReadMessage(); err != nil {
+			return
+		}
+		if handler != nil {
+			if msgData, err = handler(wsType, msgType, msgData); err != nil {
+				if errors.Is(err, ErrWsDrop) {
+					continue
+				}
+				return
+			}
+		}
+		if err = writer.write(msgType, msgData); err != nil {
+			return
+		}
+	}
+}
+
+// 解析websocket 消息并回调
+func (obj *Client) wsCopy(client *ProxyConn, server *ProxyConn) error {
+	wsServer := websocket.NewConn(client, false, server.option.wsExtensions) //和客户端通信
+	wsClient := websocket.NewConn(server, true, server.option.wsExtensions)  //和服务端通信
+	conn := &WsConn{
+		Request: client.upgradeReq,
+		Session: client.option.session,
+		client:  &wsWriter{conn: wsServer},
+		server:  &wsWriter{conn: wsClient},
+	}
+	var handler WsMessageHandler
+	if obj.wsHandler != nil {
+		handler = obj.wsHandler(conn)
+	}
+	if obj.wsCallBack != nil {
+		next := handler
+		handler = func(wsType WsType, msgType websocket.MessageType, data []byte) ([]byte, error) {
+			if err := obj.wsCallBack(msgType, data, wsType); err != nil {
+				return nil, err
+			}
+			if next != nil {
+				return next(wsType, msgType, data)
+			}
+			return data, nil
+		}
+	}
+	go func() {
+		defer obj.recoverGoroutine(client.option.session)
+		conn.copy(handler, WsRecv, wsClient, conn.client)
+	}()
+	return conn.copy(handler, WsSend, wsServer, conn.server)
+}